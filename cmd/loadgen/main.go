@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type options struct {
+	addr         string
+	players      int
+	regions      []string
+	mode         string
+	rate         float64
+	duration     time.Duration
+	pollInterval time.Duration
+	matchTimeout time.Duration
+	rampUp       time.Duration
+	tick         time.Duration
+}
+
+func main() {
+	var opts options
+	var regions string
+	flag.StringVar(&opts.addr, "addr", "http://localhost:8082", "base URL of the server")
+	flag.IntVar(&opts.players, "players", 100, "number of synthetic players")
+	flag.StringVar(&regions, "regions", "US,EU,ASIA", "comma separated regions to spread players over")
+	flag.StringVar(&opts.mode, "mode", "", "mode to queue for, the server's default when empty")
+	flag.Float64Var(&opts.rate, "rate", 20, "inputs sent per second by each player")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "how long each player stays in its match")
+	flag.DurationVar(&opts.pollInterval, "poll", 250*time.Millisecond, "match status poll interval")
+	flag.DurationVar(&opts.matchTimeout, "match-timeout", 2*time.Minute, "give up on a player that hasn't been matched by then")
+	flag.DurationVar(&opts.rampUp, "ramp-up", 5*time.Second, "spread player joins over this period")
+	flag.DurationVar(&opts.tick, "tick", 50*time.Millisecond, "game tick interval the server is configured with")
+	flag.Parse()
+	opts.regions = strings.Split(regions, ",")
+
+	if opts.players < 1 || opts.rate <= 0 {
+		log.Fatal("players and rate must be positive")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	stats := newStats()
+	runID := time.Now().Unix()
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.players; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// stagger joins so the queue fills the way it would with real traffic
+			delay := time.Duration(int64(opts.rampUp) * int64(i) / int64(opts.players))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			p := &synthPlayer{
+				id:     fmt.Sprintf("load-%d-%d", runID, i),
+				mmr:    400 + rand.Intn(600),
+				region: opts.regions[i%len(opts.regions)],
+				ping:   20 + rand.Intn(80),
+				opts:   opts,
+				stats:  stats,
+			}
+			// farther away from every region but the home one
+			p.latency = make(map[string]int, len(opts.regions))
+			for _, region := range opts.regions {
+				p.latency[region] = p.ping + 40 + rand.Intn(120)
+			}
+			p.latency[p.region] = p.ping
+
+			p.run(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	stats.report(os.Stdout, opts, time.Since(started))
+}
+
+type synthPlayer struct {
+	id      string
+	mmr     int
+	region  string
+	ping    int
+	latency map[string]int
+	opts    options
+	stats   *stats
+}
+
+type apiResponse struct {
+	Status string          `json:"status"`
+	Error  string          `json:"error"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type matchStatus struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Endpoint string `json:"endpoint"`
+}
+
+func (p *synthPlayer) run(ctx context.Context) {
+	joined := time.Now()
+	if err := p.join(ctx); err != nil {
+		p.stats.fail("join", err)
+		return
+	}
+
+	match, err := p.waitForMatch(ctx)
+	if err != nil {
+		p.stats.fail("match", err)
+		return
+	}
+	p.stats.matched(time.Since(joined))
+
+	p.play(ctx, match)
+}
+
+func (p *synthPlayer) join(ctx context.Context) error {
+	body, _ := json.Marshal(map[string]any{
+		"id":      p.id,
+		"mmr":     p.mmr,
+		"region":  p.region,
+		"ping":    p.ping,
+		"latency": p.latency,
+		"mode":    p.opts.mode,
+	})
+	_, err := p.call(ctx, http.MethodPost, "/join-queue", body)
+	return err
+}
+
+// waitForMatch polls /match-status until the player has been placed in a match
+func (p *synthPlayer) waitForMatch(ctx context.Context) (matchStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.matchTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{"id": p.id})
+	ticker := time.NewTicker(p.opts.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return matchStatus{}, ctx.Err()
+		case <-ticker.C:
+		}
+
+		data, err := p.call(ctx, http.MethodGet, "/match-status", body)
+		if err != nil {
+			continue // transient, keep polling
+		}
+		var status matchStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			return matchStatus{}, err
+		}
+		if status.Status == "matched" && status.ID != "" {
+			return status, nil
+		}
+	}
+}
+
+func (p *synthPlayer) call(ctx context.Context, method, path string, body []byte) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.opts.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var out apiResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %d %s", method, path, res.StatusCode, out.Error)
+	}
+	return out.Data, nil
+}
+
+// socketURL prefers the endpoint the allocator assigned and falls back to the API host
+func (p *synthPlayer) socketURL(match matchStatus) string {
+	endpoint := match.Endpoint
+	if endpoint == "" {
+		u, _ := url.Parse(p.opts.addr)
+		scheme := "ws"
+		if u.Scheme == "https" {
+			scheme = "wss"
+		}
+		endpoint = fmt.Sprintf("%s://%s/ws/%s", scheme, u.Host, match.ID)
+	}
+	return endpoint + "?playerID=" + url.QueryEscape(p.id)
+}
+
+type snapshot struct {
+	Tick    int64          `json:"tick"`
+	Players map[string]car `json:"players"`
+}
+
+type car struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Angle float64 `json:"angle"`
+}
+
+func (p *synthPlayer) play(ctx context.Context, match matchStatus) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, p.socketURL(match), nil)
+	if err != nil {
+		p.stats.fail("dial", err)
+		return
+	}
+	defer conn.Close()
+	p.stats.connected()
+
+	ctx, cancel := context.WithTimeout(ctx, p.opts.duration)
+	defer cancel()
+
+	// reader: measure snapshot jitter and how far the server's tick count drifts from wall time
+	readErr := make(chan error, 1)
+	placed := make(chan car, 1)
+	go func() {
+		var firstTick int64
+		var firstAt, lastAt time.Time
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			now := time.Now()
+
+			var snap snapshot
+			if err := json.Unmarshal(msg, &snap); err != nil {
+				continue
+			}
+			if firstAt.IsZero() {
+				// drive off from wherever the server put the car, anything else is a teleport
+				placed <- snap.Players[p.id]
+				firstTick, firstAt = snap.Tick, now
+			} else {
+				p.stats.snapshot(now.Sub(lastAt))
+				expected := now.Sub(firstAt)
+				actual := time.Duration(snap.Tick-firstTick) * p.opts.tick
+				p.stats.drift(expected - actual)
+			}
+			lastAt = now
+		}
+	}()
+
+	// writer: send inputs at the configured rate
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / p.opts.rate))
+	defer ticker.Stop()
+
+	var start car
+	select {
+	case <-ctx.Done():
+		return
+	case err := <-readErr:
+		p.stats.dropped(err)
+		return
+	case start = <-placed:
+	}
+
+	x, y, angle := start.X, start.Y, start.Angle
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "load test finished"))
+			}
+			return
+		case err := <-readErr:
+			p.stats.dropped(err)
+			return
+		case <-ticker.C:
+			angle += 0.05
+			x += 5 * math.Cos(angle)
+			y += 5 * math.Sin(angle)
+			input, _ := json.Marshal(map[string]any{
+				"action": "drive",
+				"payload": map[string]any{
+					"x": x, "y": y, "speed": 5, "angle": angle,
+				},
+			})
+			if err := conn.WriteMessage(websocket.TextMessage, input); err != nil {
+				p.stats.dropped(err)
+				return
+			}
+			p.stats.sent()
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type stats struct {
+	mu           sync.Mutex
+	queueToMatch []time.Duration
+	interArrival []time.Duration
+	tickDrift    []time.Duration
+	failures     map[string]int
+	lastErrors   map[string]error
+	connections  int
+	drops        int
+	inputs       int
+}
+
+func newStats() *stats {
+	return &stats{
+		failures:   make(map[string]int),
+		lastErrors: make(map[string]error),
+	}
+}
+
+func (s *stats) matched(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queueToMatch = append(s.queueToMatch, d)
+}
+
+func (s *stats) snapshot(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interArrival = append(s.interArrival, d)
+}
+
+func (s *stats) drift(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tickDrift = append(s.tickDrift, d)
+}
+
+func (s *stats) connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections++
+}
+
+func (s *stats) sent() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputs++
+}
+
+func (s *stats) dropped(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drops++
+	s.lastErrors["drop"] = err
+}
+
+func (s *stats) fail(stage string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[stage]++
+	s.lastErrors[stage] = err
+}
+
+func (s *stats) report(w io.Writer, opts options, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "players=%d rate=%.0f/s duration=%s elapsed=%s\n", opts.players, opts.rate, opts.duration, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "matched=%d connected=%d dropped=%d inputs=%d\n", len(s.queueToMatch), s.connections, s.drops, s.inputs)
+	for stage, n := range s.failures {
+		fmt.Fprintf(w, "failed at %s: %d (last error: %v)\n", stage, n, s.lastErrors[stage])
+	}
+	if s.drops > 0 {
+		fmt.Fprintf(w, "last drop: %v\n", s.lastErrors["drop"])
+	}
+
+	fmt.Fprintln(w)
+	printPercentiles(w, "queue-to-match", s.queueToMatch, nil)
+
+	// jitter is how far each snapshot arrived from the expected tick interval
+	jitter := make([]time.Duration, len(s.interArrival))
+	for i, d := range s.interArrival {
+		jitter[i] = absDuration(d - opts.tick)
+	}
+	printPercentiles(w, "snapshot jitter", jitter, nil)
+
+	// positive drift means the server produced fewer ticks than wall time allows
+	printPercentiles(w, "tick drift", s.tickDrift, absDuration)
+}
+
+// printPercentiles prints p50/p90/p99/max, ordered by key when given
+func printPercentiles(w io.Writer, name string, samples []time.Duration, key func(time.Duration) time.Duration) {
+	if len(samples) == 0 {
+		fmt.Fprintf(w, "%-16s no samples\n", name)
+		return
+	}
+	if key == nil {
+		key = func(d time.Duration) time.Duration { return d }
+	}
+
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) < key(sorted[j]) })
+
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))].Round(time.Microsecond)
+	}
+	fmt.Fprintf(w, "%-16s n=%-7d p50=%-10s p90=%-10s p99=%-10s max=%s\n",
+		name, len(sorted), at(0.50), at(0.90), at(0.99), sorted[len(sorted)-1].Round(time.Microsecond))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
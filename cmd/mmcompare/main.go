@@ -0,0 +1,130 @@
+// mmcompare replays recorded queue snapshots through every matching strategy and
+// reports what each one made of them, so a strategy change can be tried against
+// real queues before it ships.
+//
+// Record snapshots from a running deployment:
+//
+//	mmcompare -record -redis localhost:6379 -config config/local.yaml -dir snapshots
+//
+// Compare strategies on them:
+//
+//	mmcompare -dir snapshots
+//
+// Snapshots worth keeping go in internal/http/handlers/matchmaking/testdata, where
+// the matcher tests hold every strategy to golden results for them.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/matchmaking"
+	"github.com/redis/go-redis/v9"
+)
+
+// result is one snapshot's evaluations
+type result struct {
+	Snapshot    string                   `json:"snapshot"`
+	Evaluations []matchmaking.Evaluation `json:"evaluations"`
+}
+
+func main() {
+	dir := flag.String("dir", "snapshots", "directory snapshots are read from or recorded to")
+	record := flag.Bool("record", false, "record the live queues into -dir instead of comparing")
+	addr := flag.String("redis", "localhost:6379", "redis address to record from")
+	cfgPath := flag.String("config", "", "config file with the matchmaking rules to record under")
+	flag.Parse()
+
+	if *record {
+		if err := recordSnapshots(*dir, *addr, *cfgPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	results, err := compare(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(results)
+}
+
+func recordSnapshots(dir, addr, cfgPath string) error {
+	if cfgPath == "" {
+		return fmt.Errorf("-config is required to record")
+	}
+	rules, err := config.LoadMatchmaking(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	snaps, err := matchmaking.RecordSnapshots(context.Background(), rdb, rules)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for _, snap := range snaps {
+		name := fmt.Sprintf("%d_%s.json", snap.Now, strings.ReplaceAll(snap.Queue, ":", "_"))
+		data, _ := json.MarshalIndent(snap, "", "  ")
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("recorded %s (%d players)\n", name, len(snap.Players))
+	}
+	return nil
+}
+
+func compare(dir string) ([]result, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var results []result
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var snap matchmaking.Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil || snap.Queue == "" {
+			continue // not a snapshot
+		}
+
+		res := result{Snapshot: filepath.Base(file)}
+		for _, m := range matchmaking.Matchers(snap.Rules) {
+			res.Evaluations = append(res.Evaluations, matchmaking.Evaluate(snap, m))
+		}
+		results = append(results, res)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no snapshots in %s", dir)
+	}
+	return results, nil
+}
+
+func report(results []result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SNAPSHOT\tMATCHER\tMATCHED\tUNMATCHED\tTOTAL Q\tMEAN Q\tMIN Q\tMEAN WAIT\tLONGEST LEFT\tVIOLATIONS")
+	for _, res := range results {
+		for _, ev := range res.Evaluations {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t%.1fs\t%.1fs\t%d\n",
+				res.Snapshot, ev.Matcher, ev.Matched, ev.Unmatched, ev.TotalQuality, ev.MeanQuality, ev.MinQuality, ev.MeanWait, ev.LongestLeft, ev.Violations)
+		}
+	}
+	w.Flush()
+}
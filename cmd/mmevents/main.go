@@ -0,0 +1,80 @@
+// mmevents exports the matchmaking event stream as JSON lines, one event per
+// line, so the audit log can be loaded into analytics.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/events"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	addr := flag.String("redis", "localhost:6379", "redis address")
+	password := flag.String("password", "", "redis password")
+	name := flag.String("stream", "matchmaking:events", "stream to export")
+	since := flag.String("since", "", "only events at or after this RFC 3339 time")
+	until := flag.String("until", "", "only events before this RFC 3339 time")
+	batch := flag.Int64("batch", 1000, "events read per round trip")
+	flag.Parse()
+
+	start, err := streamID(*since, "-")
+	if err != nil {
+		log.Fatalf("invalid -since: %s", err)
+	}
+	end, err := streamID(*until, "+")
+	if err != nil {
+		log.Fatalf("invalid -until: %s", err)
+	}
+	if *until != "" {
+		end = "(" + end // exclusive
+	}
+
+	stream := &events.Stream{
+		Client: redis.NewClient(&redis.Options{Addr: *addr, Password: *password}),
+		Name:   *name,
+	}
+
+	ctx := context.Background()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	count := 0
+	for {
+		page, err := stream.Range(ctx, start, end, *batch)
+		if err != nil {
+			log.Fatalf("reading %s: %s", *name, err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, ev := range page {
+			if err := enc.Encode(ev); err != nil {
+				log.Fatal(err)
+			}
+		}
+		count += len(page)
+		start = "(" + page[len(page)-1].ID
+	}
+	fmt.Fprintf(os.Stderr, "exported %d events\n", count)
+}
+
+// streamID turns an RFC 3339 time into the first stream ID at that millisecond
+func streamID(value, fallback string) (string, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-0", t.UnixMilli()), nil
+}
@@ -0,0 +1,223 @@
+// mmsim runs the matchmaker's real matching code against a simulated queue, so the
+// effect of a rule change can be measured before it ships. It generates a player
+// population, or loads one, feeds it into in-memory queues on a simulated clock and
+// reports waits, MMR gaps, match rates per tier and how outliers fare.
+//
+//	mmsim -players 5000 -rate 20 -config config/local.yaml
+//	mmsim -population players.jsonl -matcher optimal
+//	mmsim -config config/local.yaml -mode casual_2v2
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+)
+
+type options struct {
+	players    int
+	rate       float64
+	mmrMean    float64
+	mmrStddev  float64
+	regions    map[string]float64
+	pingMean   float64
+	pingStddev float64
+	seed       int64
+	mode       config.Mode
+
+	pass      time.Duration // simulated time between matchmaking passes
+	drain     time.Duration // how long passes keep running after the last arrival
+	starveAt  time.Duration // a wait this long counts as starved
+	botsAfter time.Duration // bot backfill, 0 disables
+}
+
+func main() {
+	var opts options
+	var regions, population, save, cfgPath, matcher, mode string
+	flag.IntVar(&opts.players, "players", 2000, "players to generate")
+	flag.Float64Var(&opts.rate, "rate", 10, "player arrivals per simulated second")
+	flag.Float64Var(&opts.mmrMean, "mmr-mean", 600, "mean of the MMR distribution")
+	flag.Float64Var(&opts.mmrStddev, "mmr-stddev", 150, "standard deviation of the MMR distribution")
+	flag.StringVar(&regions, "regions", "US:0.5,EU:0.3,ASIA:0.2", "regions with their share of players")
+	flag.Float64Var(&opts.pingMean, "ping-mean", 60, "mean ping to the home region in ms")
+	flag.Float64Var(&opts.pingStddev, "ping-stddev", 30, "standard deviation of the home ping")
+	flag.Int64Var(&opts.seed, "seed", 1, "random seed, the same seed gives the same population")
+	flag.StringVar(&population, "population", "", "load players from this JSON lines file instead of generating them")
+	flag.StringVar(&save, "save", "", "write the population to this JSON lines file")
+	flag.StringVar(&cfgPath, "config", "", "config file with the matchmaking rules, defaults otherwise")
+	flag.StringVar(&mode, "mode", "", "mode to simulate, defaults to the default mode")
+	flag.StringVar(&matcher, "matcher", "", "use this matcher for every queue")
+	flag.DurationVar(&opts.pass, "pass", 2*time.Second, "simulated time between matchmaking passes")
+	flag.DurationVar(&opts.drain, "drain", 5*time.Minute, "keep matching this long after the last arrival")
+	flag.DurationVar(&opts.starveAt, "starve", 2*time.Minute, "wait after which a player counts as starved")
+	flag.DurationVar(&opts.botsAfter, "bots-after", 0, "backfill with a bot after this wait, 0 disables")
+	flag.Parse()
+
+	all, err := loadRules(cfgPath)
+	if err != nil {
+		log.Fatalf("loading rules: %s", err)
+	}
+	if err := all.Validate(); err != nil {
+		log.Fatalf("invalid rules: %s", err)
+	}
+	var ok bool
+	if opts.mode, ok = all.Mode(mode); !ok {
+		log.Fatalf("unknown mode %q", mode)
+	}
+	rules := all.ForMode(opts.mode)
+	if matcher != "" {
+		rules.Matcher, rules.Matchers = matcher, nil
+	}
+	if err := rules.Validate(); err != nil {
+		log.Fatalf("invalid rules: %s", err)
+	}
+
+	var players []models.Player
+	if population != "" {
+		players, err = loadPopulation(population)
+	} else {
+		opts.regions, err = parseShares(regions)
+		if err == nil {
+			players = generate(opts)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(players) == 0 {
+		log.Fatal("no players to simulate")
+	}
+
+	if save != "" {
+		if err := savePopulation(save, players); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	res := simulate(players, rules, opts)
+	res.report(os.Stdout, rules, opts)
+}
+
+func loadRules(path string) (config.Matchmaking, error) {
+	if path == "" {
+		return config.DefaultMatchmaking()
+	}
+	return config.LoadMatchmaking(path)
+}
+
+func parseShares(s string) (map[string]float64, error) {
+	shares := make(map[string]float64)
+	for _, part := range strings.Split(s, ",") {
+		name, share, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("region %q needs a share, e.g. US:0.5", part)
+		}
+		f, err := strconv.ParseFloat(share, 64)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("invalid share for region %q", name)
+		}
+		shares[name] = f
+	}
+	return shares, nil
+}
+
+// generate draws a population with Poisson arrivals and normal MMR and ping
+func generate(opts options) []models.Player {
+	rng := rand.New(rand.NewSource(opts.seed))
+
+	names := make([]string, 0, len(opts.regions))
+	total := 0.0
+	for name, share := range opts.regions {
+		names = append(names, name)
+		total += share
+	}
+	sort.Strings(names) // map order is random, keep the population reproducible
+
+	pickRegion := func() string {
+		x := rng.Float64() * total
+		for _, name := range names {
+			if x -= opts.regions[name]; x < 0 {
+				return name
+			}
+		}
+		return names[len(names)-1]
+	}
+
+	players := make([]models.Player, opts.players)
+	at := 0.0
+	for i := range players {
+		at += rng.ExpFloat64() / opts.rate
+
+		region := pickRegion()
+		ping := int(math.Max(5, rng.NormFloat64()*opts.pingStddev+opts.pingMean))
+		latency := make(map[string]int, len(names))
+		for _, name := range names {
+			latency[name] = ping + 40 + rng.Intn(160) // further away than home
+		}
+		latency[region] = ping
+
+		players[i] = models.Player{
+			ID:       fmt.Sprintf("sim-%d", i),
+			MMR:      int(math.Max(0, rng.NormFloat64()*opts.mmrStddev+opts.mmrMean)),
+			Region:   region,
+			Ping:     ping,
+			Latency:  latency,
+			JoinedAt: int64(at), // seconds since the start of the simulation
+		}
+	}
+	return players
+}
+
+// loadPopulation reads players, one JSON object per line, joined_at being seconds
+// since the start of the simulation
+func loadPopulation(path string) ([]models.Player, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var players []models.Player
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var p models.Player
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		players = append(players, p)
+	}
+	sort.SliceStable(players, func(i, j int) bool { return players[i].JoinedAt < players[j].JoinedAt })
+	return players, scanner.Err()
+}
+
+func savePopulation(path string, players []models.Player) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, p := range players {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+)
+
+func (res *result) report(w io.Writer, rules config.Matchmaking, opts options) {
+	var humans, bots, cross, unmatched int
+	for _, o := range res.outcomes {
+		switch {
+		case !o.matched:
+			unmatched++
+		case o.bot:
+			bots++
+		default:
+			humans++
+			if o.cross {
+				cross++
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "mode=%s players=%d rejected=%d passes=%d simulated=%s pass=%s\n",
+		opts.mode.Name, len(res.outcomes), res.rejected, res.passes, res.simulated, opts.pass)
+	fmt.Fprintf(w, "matcher=%s max_mmr_gap=%d fallback_after=%s bots_after=%s\n",
+		describeMatchers(rules), rules.MaxMMRGap, rules.FallbackAfter, opts.botsAfter)
+	fmt.Fprintf(w, "matches=%d matched=%d bot=%d cross_region=%d unmatched=%d\n",
+		len(res.matches), humans, bots, cross, unmatched)
+
+	fmt.Fprintln(w)
+	waits := func(keep func(outcome) bool) []time.Duration {
+		var out []time.Duration
+		for _, o := range res.outcomes {
+			if keep(o) {
+				out = append(out, o.wait)
+			}
+		}
+		return out
+	}
+	printDurations(w, "wait (matched)", waits(func(o outcome) bool { return o.matched && !o.bot }))
+	printDurations(w, "wait (bot)", waits(func(o outcome) bool { return o.bot }))
+	printDurations(w, "wait (unmatched)", waits(func(o outcome) bool { return !o.matched }))
+
+	gaps := make([]float64, len(res.matches))
+	var quality []float64
+	for i, m := range res.matches {
+		gaps[i] = float64(m.gap)
+		if m.quality >= 0 {
+			quality = append(quality, m.quality)
+		}
+	}
+	printValues(w, "mmr gap", gaps, "%.0f")
+	printValues(w, "quality", quality, "%.3f")
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-18s %7s %8s %6s %8s %10s %10s\n", "tier", "players", "matched", "bot", "starved", "p50 wait", "p90 wait")
+	for _, tier := range rules.Tiers.Names() {
+		res.printGroup(w, tier, func(o outcome) bool { return o.tier == tier }, opts)
+	}
+
+	// outliers are the ones a rule change is most likely to strand
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-18s %7s %8s %6s %8s %10s %10s\n", "outliers", "players", "matched", "bot", "starved", "p50 wait", "p90 wait")
+	lowMMR, highMMR := res.cutoffs(func(o outcome) int { return o.player.MMR })
+	_, highPing := res.cutoffs(func(o outcome) int { return o.player.Ping })
+	res.printGroup(w, fmt.Sprintf("mmr <= %d", lowMMR), func(o outcome) bool { return o.player.MMR <= lowMMR }, opts)
+	res.printGroup(w, fmt.Sprintf("mmr >= %d", highMMR), func(o outcome) bool { return o.player.MMR >= highMMR }, opts)
+	res.printGroup(w, fmt.Sprintf("ping >= %d", highPing), func(o outcome) bool { return o.player.Ping >= highPing }, opts)
+}
+
+// printGroup prints one row of match rates and waits. A player counts as starved
+// when they waited at least opts.starveAt, matched or not.
+func (res *result) printGroup(w io.Writer, name string, keep func(outcome) bool, opts options) {
+	var n, matched, bots, starved int
+	var waits []time.Duration
+	for _, o := range res.outcomes {
+		if !keep(o) {
+			continue
+		}
+		n++
+		if o.matched && !o.bot {
+			matched++
+		}
+		if o.bot {
+			bots++
+		}
+		if o.wait >= opts.starveAt {
+			starved++
+		}
+		waits = append(waits, o.wait)
+	}
+	if n == 0 {
+		fmt.Fprintf(w, "%-18s %7d\n", name, 0)
+		return
+	}
+	sortDurations(waits)
+	fmt.Fprintf(w, "%-18s %7d %7.1f%% %5.1f%% %7.1f%% %10s %10s\n", name, n,
+		percent(matched, n), percent(bots, n), percent(starved, n),
+		durationAt(waits, 0.50), durationAt(waits, 0.90))
+}
+
+// cutoffs returns the values bounding the lowest and highest 5% of players
+func (res *result) cutoffs(value func(outcome) int) (low, high int) {
+	if len(res.outcomes) == 0 {
+		return 0, 0
+	}
+	values := make([]int, len(res.outcomes))
+	for i, o := range res.outcomes {
+		values[i] = value(o)
+	}
+	sort.Ints(values)
+	return values[int(0.05*float64(len(values)-1))], values[int(0.95*float64(len(values)-1))]
+}
+
+func describeMatchers(rules config.Matchmaking) string {
+	if len(rules.Matchers) == 0 {
+		return rules.Matcher
+	}
+	return fmt.Sprintf("%s (+%d queue overrides)", rules.Matcher, len(rules.Matchers))
+}
+
+// printDurations prints p50/p90/p99/max
+func printDurations(w io.Writer, name string, samples []time.Duration) {
+	if len(samples) == 0 {
+		fmt.Fprintf(w, "%-18s no samples\n", name)
+		return
+	}
+	sortDurations(samples)
+	fmt.Fprintf(w, "%-18s n=%-7d p50=%-8s p90=%-8s p99=%-8s max=%s\n",
+		name, len(samples), durationAt(samples, 0.50), durationAt(samples, 0.90), durationAt(samples, 0.99), samples[len(samples)-1])
+}
+
+// printValues prints p50/p90/p99/max with the given format
+func printValues(w io.Writer, name string, samples []float64, format string) {
+	if len(samples) == 0 {
+		fmt.Fprintf(w, "%-18s no samples\n", name)
+		return
+	}
+	sort.Float64s(samples)
+	at := func(p float64) string {
+		return fmt.Sprintf(format, samples[int(p*float64(len(samples)-1))])
+	}
+	fmt.Fprintf(w, "%-18s n=%-7d p50=%-8s p90=%-8s p99=%-8s max=%s\n",
+		name, len(samples), at(0.50), at(0.90), at(0.99), fmt.Sprintf(format, samples[len(samples)-1]))
+}
+
+func sortDurations(d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+}
+
+func durationAt(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func percent(n, total int) float64 {
+	return 100 * float64(n) / float64(total)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/matchmaking"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+)
+
+// outcome is what happened to one simulated player
+type outcome struct {
+	player  models.Player
+	tier    string
+	matched bool
+	bot     bool
+	cross   bool // played in another region through the fallback
+	wait    time.Duration
+	gap     int // MMR difference to the opponent
+}
+
+type matchRecord struct {
+	tier    string
+	gap     int     // between the highest and lowest rated player
+	quality float64 // pairs only, -1 for other sizes
+	cross   bool
+}
+
+type result struct {
+	outcomes  []outcome
+	matches   []matchRecord
+	rejected  int // players whose region is not in the rules
+	passes    int
+	simulated time.Duration
+}
+
+// entry is a queued player as the worker would read them from a queue
+type entry struct {
+	player models.Player
+	index  int // into result.outcomes
+}
+
+// simulate replays the arrivals against in-memory queues, running a matchmaking
+// pass every opts.pass of simulated time the way the worker does for one mode, rules
+// being the mode's: every region and tier in rules order, one taken set per pass,
+// each pool reading the queues matchmaking.PoolSources lists, then bot backfill for
+// the pool's own queue.
+func simulate(players []models.Player, rules config.Matchmaking, opts options) *result {
+	res := &result{}
+	queues := make(map[string][]entry)
+	start := time.Unix(0, 0) // JoinedAt counts seconds from the start
+	last := time.Unix(players[len(players)-1].JoinedAt, 0)
+
+	matchers := make(map[string]matchmaking.Matcher)
+	matcherFor := func(region, tier string) matchmaking.Matcher {
+		key := matchmaking.GetQueueName(opts.mode.Name, region, tier)
+		if m, ok := matchers[key]; !ok && opts.mode.Size() == 2 {
+			m, _ = matchmaking.NewMatcher(rules.MatcherFor(region, tier), rules) // the rules are validated
+			matchers[key] = m
+		}
+		return matchers[key]
+	}
+
+	next := 0
+	now := start
+	for {
+		for ; next < len(players) && !time.Unix(players[next].JoinedAt, 0).After(now); next++ {
+			p := players[next]
+			if !rules.HasRegion(p.Region) {
+				res.rejected++
+				continue
+			}
+			tier := rules.Tiers.For(p.MMR)
+			res.outcomes = append(res.outcomes, outcome{player: p, tier: tier})
+			key := matchmaking.GetQueueName(opts.mode.Name, p.Region, tier)
+			queues[key] = append(queues[key], entry{player: p, index: len(res.outcomes) - 1})
+		}
+
+		taken := make(map[string]bool)
+		for _, region := range rules.Regions {
+			for _, tier := range rules.Tiers.Names() {
+				res.pass(queues, rules, region, tier, matcherFor(region, tier), opts, now, taken)
+			}
+		}
+		for key, q := range queues {
+			kept := q[:0]
+			for _, e := range q {
+				if !taken[e.player.ID] {
+					kept = append(kept, e)
+				}
+			}
+			queues[key] = kept
+		}
+		res.passes++
+
+		if next == len(players) && (queued(queues) == 0 || now.Sub(last) >= opts.drain) {
+			break
+		}
+		now = now.Add(opts.pass)
+	}
+	res.simulated = now.Sub(start)
+
+	// whoever is still queued waited until the end
+	for _, q := range queues {
+		for _, e := range q {
+			res.outcomes[e.index].wait = now.Sub(time.Unix(e.player.JoinedAt, 0))
+		}
+	}
+	return res
+}
+
+// pass runs one region and tier's pool, mirroring processSpecificQueue. The pool
+// reads the same queues as the worker's, tiers above and guests included. matcher
+// is nil for modes that don't pair two players.
+func (res *result) pass(queues map[string][]entry, rules config.Matchmaking, region, tier string, matcher matchmaking.Matcher, opts options, now time.Time, taken map[string]bool) {
+	type candidate struct {
+		entry
+		view  models.Player
+		guest bool
+		queue string
+	}
+
+	var players []candidate
+	own := matchmaking.GetQueueName(opts.mode.Name, region, tier)
+	locals, guests := matchmaking.PoolSources(rules, opts.mode.Name, region, tier)
+	for i, s := range append(locals, guests...) {
+		guest := i >= len(locals)
+		queue := matchmaking.GetQueueName(s.Mode, s.Region, s.Tier)
+		for _, e := range queues[queue] {
+			if taken[e.player.ID] || e.player.MMR > s.MaxMMR {
+				continue
+			}
+			if view, ok := matchmaking.PoolView(e.player, region, guest, rules, now); ok {
+				players = append(players, candidate{entry: e, view: view, guest: guest, queue: queue})
+			}
+		}
+	}
+	if len(players) == 0 {
+		return
+	}
+	sort.SliceStable(players, func(i, j int) bool { return players[i].view.MMR < players[j].view.MMR })
+
+	pool := make([]models.Player, len(players))
+	for i, c := range players {
+		pool[i] = c.view
+	}
+
+	var groups [][]int
+	var quality []float64
+	if matcher != nil {
+		for _, pr := range matcher.Match(pool, rules, now) {
+			groups = append(groups, []int{pr.A, pr.B})
+			quality = append(quality, pr.Quality)
+		}
+	} else {
+		groups = matchmaking.FormGroups(pool, opts.mode.Size(), rules)
+	}
+
+	for i, group := range groups {
+		free := true
+		lo, hi := math.MaxInt, math.MinInt
+		cross := false
+		for _, idx := range group {
+			c := players[idx]
+			free = free && !taken[c.player.ID]
+			lo, hi = min(lo, c.player.MMR), max(hi, c.player.MMR)
+			cross = cross || c.guest
+		}
+		if !free {
+			continue
+		}
+
+		q := -1.0
+		if i < len(quality) {
+			q = quality[i]
+		}
+		res.matches = append(res.matches, matchRecord{tier: tier, gap: hi - lo, quality: q, cross: cross})
+		for _, idx := range group {
+			c := players[idx]
+			taken[c.player.ID] = true
+			o := &res.outcomes[c.index]
+			o.matched, o.cross, o.gap = true, c.guest, hi-lo
+			o.wait = now.Sub(time.Unix(c.player.JoinedAt, 0))
+		}
+	}
+
+	if opts.botsAfter <= 0 {
+		return
+	}
+	for _, c := range players {
+		if c.queue != own || taken[c.player.ID] || now.Sub(time.Unix(c.player.JoinedAt, 0)) < opts.botsAfter {
+			continue
+		}
+		taken[c.player.ID] = true
+		o := &res.outcomes[c.index]
+		o.matched, o.bot = true, true
+		o.wait = now.Sub(time.Unix(c.player.JoinedAt, 0))
+	}
+}
+
+func queued(queues map[string][]entry) int {
+	n := 0
+	for _, q := range queues {
+		n += len(q)
+	}
+	return n
+}
@@ -2,18 +2,30 @@ package main
 
 import (
 	"context"
-	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/allocator"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases/sqlite"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/events"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/admin"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/games"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/health"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/lobbies"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/matchmaking"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/replays"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/middleware"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/socket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -21,22 +33,81 @@ func main() {
 	// load configs
 	cfg := config.MustLoad()
 
+	// setup logging
+	logger.New(cfg.Log)
+
 	// setup database
 	db, err := sqlite.New(cfg)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Failed to open database", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
 	// setup redis
 	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+		Addr:     cfg.Redis.Address,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
 	})
 	utils.SetClient(rdb)
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		// keep starting, /readyz reports unavailable until redis is reachable
+		slog.Error("Redis is unreachable", slog.String("addr", rdb.Options().Addr), slog.String("error", err.Error()))
+	}
 
 	slog.Info("Storage Initialized", slog.String("env", cfg.Env))
 
+	// tracks are read once, a broken track file stops the node from starting
+	trackCatalog, err := tracks.LoadDir(cfg.Tracks.Dir, cfg.Tracks.Default)
+	if err != nil {
+		slog.Error("Failed to load tracks", slog.String("dir", cfg.Tracks.Dir), slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	slog.Info("Tracks loaded", slog.Any("tracks", trackCatalog.Names()))
+
+	// register this process as a game node
+	alloc := allocator.New(rdb)
+	alloc.StartTimeout = cfg.GameNode.StartTimeout
+	if cfg.GameNode.ID == "" {
+		cfg.GameNode.ID, _ = os.Hostname()
+	}
+	node := allocator.Node{
+		ID:       cfg.GameNode.ID,
+		Region:   cfg.GameNode.Region,
+		Endpoint: cfg.GameNode.Endpoint,
+		Capacity: cfg.GameNode.Capacity,
+	}
+	if node.Endpoint == "" {
+		node.Endpoint = "ws://" + cfg.HTTPServer.Address
+	}
+
+	// matchmaking events, consumed by matchmaker replicas and kept as an audit log
+	stream := &events.Stream{
+		Client: rdb,
+		Name:   cfg.Events.Stream,
+		MaxLen: cfg.Events.MaxLen,
+	}
+
+	// matchmaking rules can be changed at runtime through the config file or the admin API,
+	// every replica runs with the rules kept in redis
+	rules := matchmaking.NewRuleStore(cfg.Matchmaking, rdb)
+
+	// put back anyone left waiting by a previous run
+	if err := matchmaking.RestoreQueues(context.Background(), db, cfg.Matchmaking); err != nil {
+		slog.Error("Failed to restore queues", slog.String("error", err.Error()))
+	}
+
 	// start matchmaker worker
-	go matchmaking.StartMatchmaker(db)
+	matchmakerCtx, stopMatchmaker := context.WithCancel(context.Background())
+	matchmakerDone := make(chan struct{})
+	go func() {
+		defer close(matchmakerDone)
+		matchmaking.StartMatchmaker(matchmakerCtx, db, alloc, stream, rules, cfg)
+	}()
+	go rules.Sync(matchmakerCtx, cfg.MatchmakingReload.SyncInterval)
+	if cfg.MatchmakingReload.Interval > 0 {
+		go rules.WatchFile(matchmakerCtx, cfg.Path, cfg.MatchmakingReload.Interval)
+	}
 
 	// start websocket hub
 	hub := socket.NewHub()
@@ -44,12 +115,66 @@ func main() {
 
 	// Game Manager
 	gm := socket.NewGameManager(hub)
+	gm.TickInterval = cfg.Game.TickInterval()
+	gm.AbandonAfter = cfg.Game.AbandonAfter
+	gm.DB = db
+	gm.Tracks = trackCatalog
+	gm.RulesFor = func(mode string) socket.GameRules {
+		// matches keep the rules of their mode as it is when the game starts
+		m, ok := rules.Current().Rules.Mode(mode)
+		if !ok {
+			slog.Warn("Match mode is not configured, playing without mode rules", slog.String("mode", mode))
+			return socket.GameRules{Mode: mode}
+		}
+		return socket.GameRules{Mode: m.Name, TimeLimit: m.Game.TimeLimit, Ghosts: m.Game.Ghosts, Track: m.Game.Track, Laps: m.Game.Laps}
+	}
+	if !cfg.AntiCheat.Disabled {
+		gm.AntiCheat = &socket.AntiCheatOptions{
+			Tolerance:      cfg.AntiCheat.Tolerance,
+			Slack:          cfg.AntiCheat.Slack,
+			MaxTurnRate:    cfg.AntiCheat.MaxTurnRate,
+			TeleportFactor: cfg.AntiCheat.TeleportFactor,
+			FlagScore:      cfg.AntiCheat.FlagScore,
+			KickScore:      cfg.AntiCheat.KickScore,
+			Decay:          cfg.AntiCheat.Decay,
+		}
+	}
+	gm.OnGameEnd = func(matchID string) {
+		// free the slot the allocator reserved for the match
+		if err := alloc.Release(context.Background(), node.ID, matchID); err != nil {
+			slog.Error("Failed to release game node slot", slog.String("match_id", matchID), slog.String("error", err.Error()))
+		}
+	}
+	// heartbeat once the games it reports on can be listed
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	go alloc.Heartbeat(heartbeatCtx, node, cfg.GameNode.HeartbeatInterval, gm.MatchIDs)
+
+	if !cfg.Replay.Disabled {
+		gm.Replays = &socket.ReplayStore{
+			DB:            db,
+			Dir:           cfg.Replay.Dir,
+			KeyframeEvery: cfg.Replay.KeyframeEvery,
+		}
+	}
 
 	// setup router
 	router := http.NewServeMux()
-	router.HandleFunc("POST /join-queue", matchmaking.JoinQueue(db))
+
+	checker := &health.Checker{
+		DB:                db,
+		Hub:               hub,
+		SchemaVersion:     sqlite.SchemaVersion,
+		MatchmakerRunning: matchmaking.MatchmakerRunning,
+	}
+	router.HandleFunc("GET /healthz", checker.Liveness())
+	router.HandleFunc("GET /readyz", checker.Readiness())
+	router.Handle("GET /metrics", promhttp.Handler())
+	router.HandleFunc("POST /join-queue", middleware.RejectWhileDraining(checker.ShuttingDown, matchmaking.JoinQueue(db, stream, rules)))
+	router.HandleFunc("POST /leave-queue", matchmaking.LeaveQueue(db, stream, rules))
 	router.HandleFunc("GET /match-status", matchmaking.GetMatchStatus(db))
-	router.HandleFunc("GET /ws/{match_id}", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("GET /queue/status", matchmaking.GetQueueStatus(rules))
+	router.HandleFunc("GET /queue/ws", matchmaking.QueueStatusWs(db, rules, cfg.QueueStatus.PushInterval))
+	router.HandleFunc("GET /ws/{match_id}", middleware.RejectWhileDraining(checker.ShuttingDown, func(w http.ResponseWriter, r *http.Request) {
 		matchID := r.PathValue("match_id")
 		// Query param for playerID? Or generate?
 		// For now let's assume simple connection or use random ID in ServeWs
@@ -58,11 +183,50 @@ func main() {
 			playerID = "anon" // or error
 		}
 		socket.ServeWs(hub, gm, w, r, matchID, playerID)
+	}))
+	spectatorOpts := socket.SpectatorOptions{
+		MaxPerMatch: cfg.Spectator.MaxPerMatch,
+		Delay:       cfg.Spectator.Delay,
+	}
+	router.HandleFunc("GET /ws/{match_id}/spectate", middleware.RejectWhileDraining(checker.ShuttingDown, func(w http.ResponseWriter, r *http.Request) {
+		socket.ServeSpectatorWs(hub, gm, spectatorOpts, w, r, r.PathValue("match_id"))
+	}))
+	lobbyHandler := &lobbies.Handler{
+		Redis:  rdb,
+		DB:     db,
+		Alloc:  alloc,
+		Games:  gm,
+		Tracks: trackCatalog,
+		Node:   node,
+		Config: cfg.Lobbies,
+	}
+	router.HandleFunc("POST /lobbies", middleware.RejectWhileDraining(checker.ShuttingDown, lobbyHandler.Create()))
+	router.HandleFunc("GET /lobbies/{code}", lobbyHandler.Get())
+	router.HandleFunc("POST /lobbies/{code}/join", middleware.RejectWhileDraining(checker.ShuttingDown, lobbyHandler.Join()))
+	router.HandleFunc("POST /lobbies/{code}/leave", lobbyHandler.Leave())
+	router.HandleFunc("POST /lobbies/{code}/kick", lobbyHandler.Kick())
+	router.HandleFunc("POST /lobbies/{code}/start", middleware.RejectWhileDraining(checker.ShuttingDown, lobbyHandler.Start()))
+	router.HandleFunc("GET /matches/{id}/replay", replays.Download(db))
+	router.HandleFunc("GET /matches/{id}/replay/ws", middleware.RejectWhileDraining(checker.ShuttingDown, replays.Watch(db, hub, trackCatalog)))
+	router.HandleFunc("GET /matches/{id}/spectators", func(w http.ResponseWriter, r *http.Request) {
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: response.StatusOK,
+			Data: map[string]int{
+				"spectators": hub.SpectatorCount(r.PathValue("id")),
+				"max":        spectatorOpts.MaxPerMatch,
+			},
+		})
 	})
+	if cfg.Admin.Token != "" {
+		router.HandleFunc("GET /admin/matchmaking/rules", middleware.RequireToken(cfg.Admin.Token, admin.GetMatchmakingRules(rules)))
+		router.HandleFunc("PUT /admin/matchmaking/rules", middleware.RequireToken(cfg.Admin.Token, admin.UpdateMatchmakingRules(rules)))
+		router.HandleFunc("POST /matches/{id}/bots", middleware.RequireToken(cfg.Admin.Token, middleware.RejectWhileDraining(checker.ShuttingDown, games.AddBots(gm))))
+		router.HandleFunc("GET /admin/cheat-incidents", middleware.RequireToken(cfg.Admin.Token, admin.ListCheatIncidents(db)))
+	}
 
 	server := &http.Server{
 		Addr:    cfg.HTTPServer.Address,
-		Handler: router,
+		Handler: middleware.RequestID(router),
 	}
 
 	slog.Info("Server starting", slog.String("addr", cfg.HTTPServer.Address))
@@ -72,15 +236,37 @@ func main() {
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+			slog.Error("Server failed to listen", slog.String("error", err.Error()))
+			os.Exit(1)
 		}
 	}()
 	slog.Info("Server Started")
 
 	<-done
-	slog.Info("Server Stopped")
+	// 1. stop accepting queue joins and new sockets, and fail readiness
+	checker.SetShuttingDown()
+	slog.Info("Draining")
+
+	// 2. stop taking new matches on this node
+	stopHeartbeat()
+	if err := alloc.Deregister(context.Background(), node.ID); err != nil {
+		slog.Error("Failed to deregister game node", slog.String("error", err.Error()))
+	}
+
+	// 3. stop matching and make sure everyone still queued is on record
+	stopMatchmaker()
+	<-matchmakerDone // a pass still running could match or move players after they are persisted
+	if err := matchmaking.PersistQueues(context.Background(), db, rules.Current().Rules); err != nil {
+		slog.Error("Failed to persist queues", slog.String("error", err.Error()))
+	}
+
+	// 4. let running games finish, then close the rest with a reason and save partial results
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Shutdown.DrainTimeout)
+	gm.Drain(drainCtx)
+	cancelDrain()
+	slog.Info("Games drained")
 
-	if cfg.Env == "local" || cfg.Env == "dev" {
+	if cfg.Shutdown.CleanupOnExit && (cfg.Env == "local" || cfg.Env == "dev") {
 		slog.Info("Cleaning up database and redis...")
 		if err := db.ClearTables(context.Background()); err != nil {
 			slog.Error("Failed to clear tables", slog.String("error", err.Error()))
@@ -92,7 +278,9 @@ func main() {
 		slog.Info("Cleanup complete")
 	}
 
-	if err := server.Shutdown(context.Background()); err != nil {
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		slog.Error("Server Shutdown Failed", slog.String("error", err.Error()))
 	}
 	slog.Info("Server Exited Properly")
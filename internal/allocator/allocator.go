@@ -0,0 +1,264 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+Redis layout:
+
+	nodes                 SET  of node IDs that have ever heartbeated
+	node:<id>             HASH id, region, endpoint, capacity, heartbeat (expires if the node stops heartbeating)
+	node:<id>:reserved    ZSET of match IDs reserved on that node, scored by the unix millis the
+	                      reservation lapses at. The node pushes the deadline of every game it
+	                      runs forward on each heartbeat, anything left behind is trimmed.
+*/
+const nodesKey = "nodes"
+
+// DefaultStartTimeout is how long a reserved slot waits for its game when the
+// allocator has no StartTimeout
+const DefaultStartTimeout = time.Minute
+
+var ErrNoNodeAvailable = errors.New("no game node available")
+
+// Node is a game server process that can host matches
+type Node struct {
+	ID       string `json:"id"`
+	Region   string `json:"region"`
+	Endpoint string `json:"endpoint"` // e.g. ws://10.0.0.4:8082
+	Capacity int    `json:"capacity"`
+	Used     int    `json:"used"`
+}
+
+func (n Node) Free() int {
+	return n.Capacity - n.Used
+}
+
+// Allocation is the result of reserving a slot for a match
+type Allocation struct {
+	NodeID   string
+	Endpoint string
+}
+
+// reserveScript atomically checks the node is alive and, once lapsed reservations
+// are dropped, has a free slot before reserving it until the deadline.
+// ARGV: match ID, now, deadline, both unix millis
+var reserveScript = redis.NewScript(`
+local capacity = tonumber(redis.call('HGET', KEYS[1], 'capacity'))
+if not capacity then
+	return 0
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[2])
+if redis.call('ZCARD', KEYS[2]) >= capacity then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+type Allocator struct {
+	rdb *redis.Client
+
+	// StartTimeout releases a reserved slot whose game hasn't started on its node by
+	// then, DefaultStartTimeout when 0
+	StartTimeout time.Duration
+}
+
+func New(rdb *redis.Client) *Allocator {
+	return &Allocator{rdb: rdb}
+}
+
+func nodeKey(nodeID string) string {
+	return fmt.Sprintf("node:%s", nodeID)
+}
+
+func nodeMatchesKey(nodeID string) string {
+	return fmt.Sprintf("node:%s:reserved", nodeID)
+}
+
+func (a *Allocator) deadline(after time.Duration) int64 {
+	return time.Now().Add(after).UnixMilli()
+}
+
+// Heartbeat registers the node and refreshes it every interval until ctx is cancelled.
+// running lists the matches the node is hosting, their slots are held for another
+// three intervals each time. A node that stops heartbeating expires after three
+// missed intervals, and so do the slots of its games.
+func (a *Allocator) Heartbeat(ctx context.Context, node Node, interval time.Duration, running func() []string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.register(ctx, node, 3*interval); err != nil {
+			slog.Error("Node heartbeat failed", slog.String("node", node.ID), slog.String("error", err.Error()))
+		}
+		if err := a.hold(ctx, node.ID, running(), 3*interval); err != nil {
+			slog.Error("Failed to hold running matches' slots", slog.String("node", node.ID), slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Allocator) register(ctx context.Context, node Node, ttl time.Duration) error {
+	pipe := a.rdb.TxPipeline()
+	pipe.HSet(ctx, nodeKey(node.ID), map[string]any{
+		"id":        node.ID,
+		"region":    node.Region,
+		"endpoint":  node.Endpoint,
+		"capacity":  node.Capacity,
+		"heartbeat": time.Now().Unix(),
+	})
+	pipe.Expire(ctx, nodeKey(node.ID), ttl)
+	pipe.SAdd(ctx, nodesKey, node.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// hold pushes the deadlines of running matches forward, re-adding any whose
+// reservation lapsed before their game started
+func (a *Allocator) hold(ctx context.Context, nodeID string, matchIDs []string, ttl time.Duration) error {
+	if len(matchIDs) == 0 {
+		return nil
+	}
+	deadline := float64(a.deadline(ttl))
+	members := make([]redis.Z, len(matchIDs))
+	for i, id := range matchIDs {
+		members[i] = redis.Z{Score: deadline, Member: id}
+	}
+	return a.rdb.ZAdd(ctx, nodeMatchesKey(nodeID), members...).Err()
+}
+
+// Deregister removes the node so no new matches get routed to it
+func (a *Allocator) Deregister(ctx context.Context, nodeID string) error {
+	pipe := a.rdb.TxPipeline()
+	pipe.Del(ctx, nodeKey(nodeID))
+	pipe.SRem(ctx, nodesKey, nodeID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Nodes returns every live node with its current slot usage
+func (a *Allocator) Nodes(ctx context.Context) ([]Node, error) {
+	ids, err := a.rdb.SMembers(ctx, nodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(ids))
+	for _, id := range ids {
+		fields, err := a.rdb.HGetAll(ctx, nodeKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			// heartbeat expired, node is gone
+			a.rdb.SRem(ctx, nodesKey, id)
+			continue
+		}
+
+		capacity, _ := strconv.Atoi(fields["capacity"])
+		used, err := a.rdb.ZCount(ctx, nodeMatchesKey(id), fmt.Sprintf("(%d", time.Now().UnixMilli()), "+inf").Result()
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, Node{
+			ID:       id,
+			Region:   fields["region"],
+			Endpoint: fields["endpoint"],
+			Capacity: capacity,
+			Used:     int(used),
+		})
+	}
+	return nodes, nil
+}
+
+// Allocate reserves a slot for matchID, preferring nodes in the given region and
+// then the least loaded node. Nodes in other regions are only used as a fallback.
+func (a *Allocator) Allocate(ctx context.Context, region, matchID string) (Allocation, error) {
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return Allocation{}, err
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		iLocal, jLocal := nodes[i].Region == region, nodes[j].Region == region
+		if iLocal != jLocal {
+			return iLocal
+		}
+		return nodes[i].Free() > nodes[j].Free()
+	})
+
+	for _, node := range nodes {
+		if node.Free() <= 0 {
+			continue
+		}
+
+		ok, err := a.reserve(ctx, node.ID, matchID)
+		if err != nil {
+			return Allocation{}, err
+		}
+		if ok {
+			return Allocation{
+				NodeID:   node.ID,
+				Endpoint: fmt.Sprintf("%s/ws/%s", node.Endpoint, matchID),
+			}, nil
+		}
+		// lost the race for the last slot, try the next node
+	}
+
+	return Allocation{}, ErrNoNodeAvailable
+}
+
+// Reserve takes a slot for matchID on one particular node, for matches that have
+// to run where they were set up
+func (a *Allocator) Reserve(ctx context.Context, nodeID, matchID string) (Allocation, error) {
+	endpoint, err := a.rdb.HGet(ctx, nodeKey(nodeID), "endpoint").Result()
+	if errors.Is(err, redis.Nil) {
+		return Allocation{}, ErrNoNodeAvailable // not heartbeating
+	}
+	if err != nil {
+		return Allocation{}, err
+	}
+
+	ok, err := a.reserve(ctx, nodeID, matchID)
+	if err != nil {
+		return Allocation{}, err
+	}
+	if !ok {
+		return Allocation{}, ErrNoNodeAvailable
+	}
+	return Allocation{
+		NodeID:   nodeID,
+		Endpoint: fmt.Sprintf("%s/ws/%s", endpoint, matchID),
+	}, nil
+}
+
+// reserve holds a slot on the node until StartTimeout, when there is one free
+func (a *Allocator) reserve(ctx context.Context, nodeID, matchID string) (bool, error) {
+	timeout := a.StartTimeout
+	if timeout <= 0 {
+		timeout = DefaultStartTimeout
+	}
+	ok, err := reserveScript.Run(ctx, a.rdb, []string{nodeKey(nodeID), nodeMatchesKey(nodeID)},
+		matchID, time.Now().UnixMilli(), a.deadline(timeout)).Int()
+	return ok == 1, err
+}
+
+// Release frees the slot held by matchID on the node
+func (a *Allocator) Release(ctx context.Context, nodeID, matchID string) error {
+	return a.rdb.ZRem(ctx, nodeMatchesKey(nodeID), matchID).Err()
+}
@@ -4,18 +4,155 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
+var validate = validator.New()
+
+type Redis struct{
+	Address string `yaml:"address" env:"REDIS_ADDRESS" env-default:"localhost:6379" validate:"hostname_port"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB int `yaml:"db" env:"REDIS_DB" env-default:"0" validate:"gte=0"`
+}
+
+// Game controls the simulation loop
+type Game struct{
+	TickRate int `yaml:"tick_rate" env:"GAME_TICK_RATE" env-default:"20" validate:"min=1,max=120"` // ticks per second
+	AbandonAfter time.Duration `yaml:"abandon_after" env:"GAME_ABANDON_AFTER" env-default:"30s" validate:"gte=0"` // games nobody is connected to for this long are ended, 0 disables
+}
+
+func (g Game) TickInterval() time.Duration {
+	return time.Second / time.Duration(g.TickRate)
+}
+
 type HTTPServer struct{
 	Address string `yaml:"address" env-required:"true"`
 }
 
+// GameNode describes this process as a game server the allocator can route matches to
+type GameNode struct{
+	ID string `yaml:"id" env:"NODE_ID"` // defaults to the hostname
+	Region string `yaml:"region" env:"NODE_REGION" env-default:"US"`
+	Endpoint string `yaml:"endpoint" env:"NODE_ENDPOINT"` // public websocket base URL, defaults to ws://<http_server.address>
+	Capacity int `yaml:"capacity" env:"NODE_CAPACITY" env-default:"100" validate:"gt=0"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env:"NODE_HEARTBEAT_INTERVAL" env-default:"5s" validate:"gt=0"`
+	StartTimeout time.Duration `yaml:"start_timeout" env:"NODE_START_TIMEOUT" env-default:"60s" validate:"gt=0"` // a reserved slot is freed if its game hasn't started by then
+}
+
+// Tracks says where track definitions are loaded from
+type Tracks struct{
+	Dir string `yaml:"dir" env:"TRACKS_DIR" env-default:"tracks" validate:"required"`
+	Default string `yaml:"default" env:"TRACKS_DEFAULT" env-default:"oval" validate:"required"` // raced when neither the match nor its mode picks one
+}
+
+// AntiCheat checks player inputs against what their car can actually do
+type AntiCheat struct{
+	Disabled bool `yaml:"disabled" env:"ANTICHEAT_DISABLED"` // inputs are trusted as sent when turned off
+	Tolerance float64 `yaml:"tolerance" env:"ANTICHEAT_TOLERANCE" env-default:"0.25" validate:"gte=0"` // share over MaxSpeed allowed for timing jitter
+	Slack float64 `yaml:"slack" env:"ANTICHEAT_SLACK" env-default:"25" validate:"gte=0"` // distance a car may be off on top of that, for collision push-outs
+	MaxTurnRate float64 `yaml:"max_turn_rate" env:"ANTICHEAT_MAX_TURN_RATE" env-default:"0.3" validate:"gt=0"` // radians per tick
+	TeleportFactor float64 `yaml:"teleport_factor" env:"ANTICHEAT_TELEPORT_FACTOR" env-default:"3" validate:"gt=1"` // moves this many times too far are dropped instead of clamped
+	FlagScore float64 `yaml:"flag_score" env:"ANTICHEAT_FLAG_SCORE" env-default:"10" validate:"gt=0"`
+	KickScore float64 `yaml:"kick_score" env:"ANTICHEAT_KICK_SCORE" env-default:"30" validate:"gtfield=FlagScore"`
+	Decay float64 `yaml:"decay" env:"ANTICHEAT_DECAY" env-default:"1" validate:"gte=0"` // suspicion forgiven per second
+}
+
+// Spectator limits read-only connections to live matches
+type Spectator struct{
+	MaxPerMatch int `yaml:"max_per_match" env:"SPECTATOR_MAX_PER_MATCH" env-default:"50" validate:"gte=0"`
+	Delay time.Duration `yaml:"delay" env:"SPECTATOR_DELAY" env-default:"0s" validate:"gte=0"`
+}
+
+// Replay controls match recording
+type Replay struct{
+	Disabled bool `yaml:"disabled" env:"REPLAY_DISABLED"` // recording is on unless turned off
+	Dir string `yaml:"dir" env:"REPLAY_DIR" env-default:"storage/replays" validate:"required"`
+	KeyframeEvery int64 `yaml:"keyframe_every" env:"REPLAY_KEYFRAME_EVERY" env-default:"100" validate:"gt=0"` // in ticks
+}
+
+// Bots controls server-driven players
+type Bots struct{
+	BackfillAfter time.Duration `yaml:"backfill_after" env:"BOTS_BACKFILL_AFTER" env-default:"0s" validate:"gte=0"` // 0 disables backfilling
+	Difficulty string `yaml:"difficulty" env:"BOTS_DIFFICULTY" env-default:"medium" validate:"oneof=easy medium hard"`
+}
+
+// Log controls the process logger
+type Log struct{
+	Level string `yaml:"level" env:"LOG_LEVEL" env-default:"info" validate:"oneof=debug info warn error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" env-default:"text" validate:"oneof=text json"`
+}
+
+// Shutdown controls how the node drains on SIGTERM
+type Shutdown struct{
+	DrainTimeout time.Duration `yaml:"drain_timeout" env:"SHUTDOWN_DRAIN_TIMEOUT" env-default:"30s" validate:"gt=0"` // how long running games get to finish
+	CleanupOnExit bool `yaml:"cleanup_on_exit" env:"SHUTDOWN_CLEANUP_ON_EXIT" env-default:"false"` // wipe tables and redis after draining, local/dev only
+}
+
+// MatchmakingReload controls picking up rule changes without a restart
+type MatchmakingReload struct{
+	Interval time.Duration `yaml:"interval" env:"MATCHMAKING_RELOAD_INTERVAL" env-default:"10s" validate:"gte=0"` // how often the config file is checked, 0 disables
+	SyncInterval time.Duration `yaml:"sync_interval" env:"MATCHMAKING_SYNC_INTERVAL" env-default:"2s" validate:"gt=0"` // how often rules applied by other replicas are picked up from redis
+}
+
+// MatchmakingWorker controls when the matchmaker runs a pass over the queues
+type MatchmakingWorker struct{
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"MATCHMAKING_SWEEP_INTERVAL" env-default:"2s" validate:"gt=0"` // max time between passes while idle
+	Debounce time.Duration `yaml:"debounce" env:"MATCHMAKING_DEBOUNCE" env-default:"100ms" validate:"gte=0"` // wakeups within this window share one pass
+	OutboxInterval time.Duration `yaml:"outbox_interval" env:"MATCHMAKING_OUTBOX_INTERVAL" env-default:"1s" validate:"gt=0"` // how often side effects left in the outbox are retried
+}
+
+// QueueStatus controls what queued players are told about their wait
+type QueueStatus struct{
+	PushInterval time.Duration `yaml:"push_interval" env:"QUEUE_STATUS_PUSH_INTERVAL" env-default:"2s" validate:"gt=0"` // how often the queue socket sends an update
+	Samples int64 `yaml:"samples" env:"QUEUE_STATUS_SAMPLES" env-default:"100" validate:"gt=0"` // recent times to match kept per region and tier
+}
+
+// Events controls the matchmaking event stream
+type Events struct{
+	Stream string `yaml:"stream" env:"EVENTS_STREAM" env-default:"matchmaking:events" validate:"required"`
+	Group string `yaml:"group" env:"EVENTS_GROUP" env-default:"matchmakers" validate:"required"` // consumer group shared by matchmaker replicas
+	ClaimAfter time.Duration `yaml:"claim_after" env:"EVENTS_CLAIM_AFTER" env-default:"30s" validate:"gt=0"` // unacknowledged events are taken over after this
+	MaxLen int64 `yaml:"max_len" env:"EVENTS_MAX_LEN" env-default:"1000000" validate:"gte=0"` // approximate audit log length, 0 keeps everything
+}
+
+// Lobbies controls private lobbies players join by code
+type Lobbies struct{
+	CodeLength int `yaml:"code_length" env:"LOBBY_CODE_LENGTH" env-default:"6" validate:"min=4,max=12"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"LOBBY_IDLE_TIMEOUT" env-default:"30m" validate:"gt=0"` // a lobby nobody has touched for this long is dropped
+	MaxLaps int `yaml:"max_laps" env:"LOBBY_MAX_LAPS" env-default:"20" validate:"min=1"`
+	DefaultMaxPlayers int `yaml:"default_max_players" env:"LOBBY_DEFAULT_MAX_PLAYERS" env-default:"8" validate:"min=2,max=32"`
+}
+
+// Admin protects the admin API
+type Admin struct{
+	Token string `yaml:"token" env:"ADMIN_TOKEN"` // bearer token, the admin API is off when empty
+}
+
 type Config struct{
+	Path string `yaml:"-"` // file the config was loaded from
 	Env string `yaml:"env" env:"ENV" env-required:"true" env-default:"production"`
 	StoragePath string `yaml:"storage_path" env-required:"true"`
 	HTTPServer	`yaml:"http_server"`
+	Redis Redis `yaml:"redis"`
+	Matchmaking Matchmaking `yaml:"matchmaking"`
+	MatchmakingReload MatchmakingReload `yaml:"matchmaking_reload"`
+	MatchmakingWorker MatchmakingWorker `yaml:"matchmaking_worker"`
+	Events Events `yaml:"events"`
+	QueueStatus QueueStatus `yaml:"queue_status"`
+	Lobbies Lobbies `yaml:"lobbies"`
+	Admin Admin `yaml:"admin"`
+	Game Game `yaml:"game"`
+	Tracks Tracks `yaml:"tracks"`
+	AntiCheat AntiCheat `yaml:"anticheat"`
+	GameNode GameNode `yaml:"game_node"`
+	Spectator Spectator `yaml:"spectator"`
+	Replay Replay `yaml:"replay"`
+	Bots Bots `yaml:"bots"`
+	Log Log `yaml:"log"`
+	Shutdown Shutdown `yaml:"shutdown"`
 }
 
 func MustLoad() *Config{
@@ -47,5 +184,18 @@ func MustLoad() *Config{
 		log.Fatalf("cannot read config file: %s",err.Error())
 	}
 
+	if err := cfg.Validate(); err != nil{
+		log.Fatalf("invalid config: %s",err.Error())
+	}
+	cfg.Path = configPath
+
 	return &cfg
+}
+
+// Validate checks every section, including the rules cleanenv can't express
+func (c *Config) Validate() error{
+	if err := validate.Struct(c); err != nil{
+		return err
+	}
+	return c.Matchmaking.Validate()
 }
\ No newline at end of file
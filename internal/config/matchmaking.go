@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// Tier is a rating band. A player belongs to the highest tier whose MinMMR they reach.
+type Tier struct {
+	Name   string `yaml:"name" json:"name" validate:"required"`
+	MinMMR int    `yaml:"min_mmr" json:"min_mmr"`
+}
+
+// Tiers is the tier table, ordered by MinMMR. From the environment it is read as
+// name:min_mmr pairs, e.g. MATCHMAKING_TIERS="newbie:0,specialist:500".
+type Tiers []Tier
+
+// SetValue lets cleanenv parse Tiers from an environment variable
+func (t *Tiers) SetValue(s string) error {
+	var tiers Tiers
+	for _, pair := range strings.Split(s, ",") {
+		name, min, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return fmt.Errorf("tier %q is not name:min_mmr", pair)
+		}
+		minMMR, err := strconv.Atoi(min)
+		if err != nil {
+			return fmt.Errorf("tier %q: %w", pair, err)
+		}
+		tiers = append(tiers, Tier{Name: name, MinMMR: minMMR})
+	}
+	*t = tiers
+	return nil
+}
+
+// For returns the tier a rating falls in. Ratings below the first tier count as the first tier.
+func (t Tiers) For(mmr int) string {
+	tier := t[0].Name
+	for _, candidate := range t {
+		if mmr < candidate.MinMMR {
+			break
+		}
+		tier = candidate.Name
+	}
+	return tier
+}
+
+func (t Tiers) Names() []string {
+	names := make([]string, len(t))
+	for i, tier := range t {
+		names[i] = tier.Name
+	}
+	return names
+}
+
+// Span returns the tiers that ratings from lo to hi fall in, lowest first
+func (t Tiers) Span(lo, hi int) []string {
+	var names []string
+	for i, tier := range t {
+		if i+1 < len(t) && t[i+1].MinMMR <= lo {
+			continue // ends below lo
+		}
+		if i > 0 && tier.MinMMR > hi {
+			break
+		}
+		names = append(names, tier.Name)
+	}
+	return names
+}
+
+func (t Tiers) validate() error {
+	seen := make(map[string]bool, len(t))
+	for i, tier := range t {
+		if seen[tier.Name] {
+			return fmt.Errorf("tier %q is listed twice", tier.Name)
+		}
+		seen[tier.Name] = true
+
+		if i > 0 && tier.MinMMR <= t[i-1].MinMMR {
+			return fmt.Errorf("tier %q must start above %q (%d <= %d)", tier.Name, t[i-1].Name, tier.MinMMR, t[i-1].MinMMR)
+		}
+	}
+	return nil
+}
+
+// Matchmaking holds the rules the matchmaker pairs players with
+type Matchmaking struct {
+	Regions   []string `yaml:"regions" json:"regions" env:"MATCHMAKING_REGIONS" env-default:"US,EU,ASIA" validate:"min=1,dive,required"`
+	Tiers     Tiers    `yaml:"tiers" json:"tiers" env:"MATCHMAKING_TIERS" env-default:"newbie:0,specialist:500,expert:700,candidate_master:900" validate:"min=1,dive"`
+	MaxMMRGap int      `yaml:"max_mmr_gap" json:"max_mmr_gap" env:"MATCHMAKING_MAX_MMR_GAP" env-default:"100" validate:"gt=0"`
+
+	// Cross-region fallback: once a player has waited FallbackAfter they can be matched in
+	// any other region their reported ping to is at most FallbackMaxPing
+	FallbackAfter   time.Duration `yaml:"fallback_after" json:"fallback_after" env:"MATCHMAKING_FALLBACK_AFTER" env-default:"0s" validate:"gte=0"` // 0 disables the fallback
+	FallbackMaxPing int           `yaml:"fallback_max_ping" json:"fallback_max_ping" env:"MATCHMAKING_FALLBACK_MAX_PING" env-default:"120" validate:"gt=0"`
+
+	MaxPing     int `yaml:"max_ping" json:"max_ping" env:"MATCHMAKING_MAX_PING" env-default:"200" validate:"gt=0"`                // highest ping either player may have
+	MaxPingDiff int `yaml:"max_ping_diff" json:"max_ping_diff" env:"MATCHMAKING_MAX_PING_DIFF" env-default:"80" validate:"gte=0"` // largest ping difference within a pair
+
+	Quality Quality `yaml:"quality" json:"quality"`
+
+	Matcher       string        `yaml:"matcher" json:"matcher" env:"MATCHMAKING_MATCHER" env-default:"greedy" validate:"oneof=greedy optimal fifo"` // strategy used unless a queue overrides it
+	MatcherWindow int           `yaml:"matcher_window" json:"matcher_window" env:"MATCHMAKING_MATCHER_WINDOW" env-default:"12" validate:"min=2,max=16"` // players the optimal matcher solves at once
+	Matchers      []MatcherRule `yaml:"matchers" json:"matchers,omitempty" validate:"dive"`
+
+	DefaultMode string `yaml:"default_mode" json:"default_mode" env:"MATCHMAKING_DEFAULT_MODE" env-default:"ranked_1v1" validate:"required"` // mode of joins that don't name one
+	Modes       []Mode `yaml:"modes" json:"modes,omitempty" validate:"dive"`                                                                 // when empty, DefaultMode is the only mode, a 1v1
+}
+
+// MaxMatchSize is the most players a mode can put in one match
+const MaxMatchSize = 32
+
+// Mode is a way to play with its own queues. A match has Teams teams of TeamSize players.
+type Mode struct {
+	Name     string    `yaml:"name" json:"name" validate:"required,excludes=:"`
+	TeamSize int       `yaml:"team_size" json:"team_size" validate:"min=1"`
+	Teams    int       `yaml:"teams" json:"teams" validate:"min=1"`
+	Rating   string    `yaml:"rating" json:"rating,omitempty"` // rating pool players are matched on, defaults to Name
+	Rules    ModeRules `yaml:"rules" json:"rules"`
+	Game     GameRules `yaml:"game" json:"game"`
+}
+
+// Size is the number of players in one of the mode's matches
+func (m Mode) Size() int {
+	return m.TeamSize * m.Teams
+}
+
+func (m Mode) RatingPool() string {
+	if m.Rating == "" {
+		return m.Name
+	}
+	return m.Rating
+}
+
+// ModeRules overrides the shared matchmaking rules for one mode. Zero values keep
+// the shared rule.
+type ModeRules struct {
+	Tiers     Tiers  `yaml:"tiers" json:"tiers,omitempty" validate:"dive"`
+	MaxMMRGap int    `yaml:"max_mmr_gap" json:"max_mmr_gap,omitempty" validate:"gte=0"`
+	MaxPing   int    `yaml:"max_ping" json:"max_ping,omitempty" validate:"gte=0"`
+	Matcher   string `yaml:"matcher" json:"matcher,omitempty" validate:"omitempty,oneof=greedy optimal fifo"` // replaces Matcher and Matchers for every queue of the mode
+}
+
+// GameRules is how the game server runs a mode's matches
+type GameRules struct {
+	TimeLimit time.Duration `yaml:"time_limit" json:"time_limit,omitempty" validate:"gte=0"` // the match ends after this, 0 for no limit
+	Ghosts    bool          `yaml:"ghosts" json:"ghosts,omitempty"`                          // cars drive through each other
+	Track     string        `yaml:"track" json:"track,omitempty"`                            // defaults to the server's default track
+	Laps      int           `yaml:"laps" json:"laps,omitempty" validate:"gte=0"`             // 0 races the track's own lap count
+}
+
+// ModeList returns the configured modes, or the implicit 1v1 DefaultMode when there are none
+func (m Matchmaking) ModeList() []Mode {
+	if len(m.Modes) == 0 {
+		return []Mode{{Name: m.DefaultMode, TeamSize: 1, Teams: 2}}
+	}
+	return m.Modes
+}
+
+// Mode looks a mode up by name, an empty name being DefaultMode
+func (m Matchmaking) Mode(name string) (Mode, bool) {
+	if name == "" {
+		name = m.DefaultMode
+	}
+	for _, mode := range m.ModeList() {
+		if mode.Name == name {
+			return mode, true
+		}
+	}
+	return Mode{}, false
+}
+
+// ForMode returns the rules the mode's queues are matched with
+func (m Matchmaking) ForMode(mode Mode) Matchmaking {
+	if len(mode.Rules.Tiers) > 0 {
+		m.Tiers = mode.Rules.Tiers
+	}
+	if mode.Rules.MaxMMRGap > 0 {
+		m.MaxMMRGap = mode.Rules.MaxMMRGap
+	}
+	if mode.Rules.MaxPing > 0 {
+		m.MaxPing = mode.Rules.MaxPing
+	}
+	if mode.Rules.Matcher != "" {
+		m.Matcher, m.Matchers = mode.Rules.Matcher, nil
+	}
+	return m
+}
+
+// MatcherNames lists the matching strategies
+var MatcherNames = []string{"greedy", "optimal", "fifo"}
+
+// MatcherRule picks the matching strategy for some queues. An empty Region or
+// Tier matches any.
+type MatcherRule struct {
+	Region  string `yaml:"region" json:"region,omitempty"`
+	Tier    string `yaml:"tier" json:"tier,omitempty"`
+	Matcher string `yaml:"matcher" json:"matcher" validate:"oneof=greedy optimal fifo"`
+}
+
+// MatcherFor returns the strategy for a queue. The most specific rule wins,
+// then the earliest listed.
+func (m Matchmaking) MatcherFor(region, tier string) string {
+	name, score := m.Matcher, 0
+	for _, rule := range m.Matchers {
+		if (rule.Region != "" && rule.Region != region) || (rule.Tier != "" && rule.Tier != tier) {
+			continue
+		}
+		s := 1
+		if rule.Region != "" {
+			s++
+		}
+		if rule.Tier != "" {
+			s++
+		}
+		if s > score {
+			name, score = rule.Matcher, s
+		}
+	}
+	return name
+}
+
+// Quality weighs what makes a good pairing. Each term is scaled to 0..1 and the
+// weighted average is the match quality, 1 being a perfect pair.
+type Quality struct {
+	MMRWeight   float64       `yaml:"mmr_weight" json:"mmr_weight" env:"MATCHMAKING_QUALITY_MMR_WEIGHT" env-default:"0.5" validate:"gte=0"`
+	PingWeight  float64       `yaml:"ping_weight" json:"ping_weight" env:"MATCHMAKING_QUALITY_PING_WEIGHT" env-default:"0.3" validate:"gte=0"`
+	WaitWeight  float64       `yaml:"wait_weight" json:"wait_weight" env:"MATCHMAKING_QUALITY_WAIT_WEIGHT" env-default:"0.2" validate:"gte=0"`
+	WaitHorizon time.Duration `yaml:"wait_horizon" json:"wait_horizon" env:"MATCHMAKING_QUALITY_WAIT_HORIZON" env-default:"60s" validate:"gt=0"` // wait at which the wait term maxes out
+}
+
+func (q Quality) totalWeight() float64 {
+	return q.MMRWeight + q.PingWeight + q.WaitWeight
+}
+
+func (m Matchmaking) Validate() error {
+	if err := validate.Struct(m); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(m.Regions))
+	for _, region := range m.Regions {
+		if seen[region] {
+			return fmt.Errorf("region %q is listed twice", region)
+		}
+		seen[region] = true
+	}
+	if m.Quality.totalWeight() <= 0 {
+		return fmt.Errorf("at least one quality weight must be positive")
+	}
+	if err := m.Tiers.validate(); err != nil {
+		return err
+	}
+
+	modes := make(map[string]bool, len(m.Modes))
+	for _, mode := range m.Modes {
+		if modes[mode.Name] {
+			return fmt.Errorf("mode %q is listed twice", mode.Name)
+		}
+		modes[mode.Name] = true
+
+		if mode.Size() > MaxMatchSize {
+			return fmt.Errorf("mode %q puts %d players in a match, at most %d are allowed", mode.Name, mode.Size(), MaxMatchSize)
+		}
+		if err := mode.Rules.Tiers.validate(); err != nil {
+			return fmt.Errorf("mode %q: %w", mode.Name, err)
+		}
+	}
+	if _, ok := m.Mode(m.DefaultMode); !ok {
+		return fmt.Errorf("default mode %q is not one of the modes", m.DefaultMode)
+	}
+	return nil
+}
+
+func (m Matchmaking) HasRegion(region string) bool {
+	for _, r := range m.Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadMatchmaking re-reads the matchmaking section of a config file, environment overrides included
+func LoadMatchmaking(path string) (Matchmaking, error) {
+	var cfg Config
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return Matchmaking{}, err
+	}
+	return cfg.Matchmaking, nil
+}
+
+// DefaultMatchmaking returns the matchmaking rules with every default applied,
+// environment overrides included
+func DefaultMatchmaking() (Matchmaking, error) {
+	var m Matchmaking
+	if err := cleanenv.ReadEnv(&m); err != nil {
+		return Matchmaking{}, err
+	}
+	return m, m.Validate()
+}
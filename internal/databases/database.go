@@ -2,13 +2,35 @@ package databases
 
 import (
 	"context"
+	"errors"
 
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
 )
 
+// ErrPlayerNotWaiting is returned by CreateMatch when a player has already been
+// matched or left the queue
+var ErrPlayerNotWaiting = errors.New("player is not waiting")
+
+// ErrPlayerAlreadyQueued is returned by CreatePlayer for a player who is still waiting
+var ErrPlayerAlreadyQueued = errors.New("player is already queued")
+
 type Database interface {
-	CreatePlayer(ctx context.Context, player models.Player) error
-	CreateMatch(ctx context.Context, match models.Match) error
+	CreatePlayer(ctx context.Context, player models.Player, tier string) error
+	CreateMatch(ctx context.Context, match models.Match, outbox ...models.OutboxMessage) error
 	GetMatch(ctx context.Context, playerID string) (models.Match, error)
+	GetMatchByID(ctx context.Context, matchID string) (models.Match, error)
+	EndMatch(ctx context.Context, matchID, status string, results []models.PlayerResult) error
+	GetWaitingPlayers(ctx context.Context) ([]models.Player, error)
+	MarkPlayersWaiting(ctx context.Context, playerIDs []string) error
+	MarkPlayerLeft(ctx context.Context, playerID string) error
+	CreateReplay(ctx context.Context, replay models.Replay) error
+	GetReplay(ctx context.Context, matchID string) (models.Replay, error)
+	CreateCheatIncident(ctx context.Context, incident models.CheatIncident) error
+	GetCheatIncidents(ctx context.Context, playerID string, limit int) ([]models.CheatIncident, error)
+	PendingOutbox(ctx context.Context, limit int) ([]models.OutboxMessage, error)
+	MarkOutboxDone(ctx context.Context, id int64) error
+	MarkOutboxFailed(ctx context.Context, id int64, reason string) error
 	ClearTables(ctx context.Context) error
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int64, error)
 }
@@ -3,13 +3,19 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// SchemaVersion is the latest goose migration this code expects to have been applied
+const SchemaVersion int64 = 20260128151237
+
 type SQLite struct {
 	Db *sql.DB
 }
@@ -23,16 +29,29 @@ func New(cfg *config.Config) (*SQLite, error) {
 	return &SQLite{Db: db}, nil
 }
 
-func (s *SQLite) CreatePlayer(ctx context.Context, player models.Player) error {
-	query := `INSERT INTO players (id, mmr, ping, region, tier, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+// CreatePlayer queues a player, or queues them again with their new details once
+// they have left or been matched. Fails with databases.ErrPlayerAlreadyQueued while
+// they are still waiting.
+func (s *SQLite) CreatePlayer(ctx context.Context, player models.Player, tier string) error {
+	query := `INSERT INTO players (id, mmr, ping, region, tier, latency, mode, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			status = 'waiting',
+			mmr = excluded.mmr,
+			ping = excluded.ping,
+			region = excluded.region,
+			tier = excluded.tier,
+			latency = excluded.latency,
+			mode = excluded.mode,
+			created_at = excluded.created_at
+		WHERE players.status != 'waiting'`
 
-	tier := "candidate_master"
-	if player.MMR < 500 {
-		tier = "newbie"
-	} else if player.MMR < 700 {
-		tier = "specialist"
-	} else if player.MMR < 900 {
-		tier = "expert"
+	var latency sql.NullString
+	if len(player.Latency) > 0 {
+		b, err := json.Marshal(player.Latency)
+		if err != nil {
+			return err
+		}
+		latency = sql.NullString{String: string(b), Valid: true}
 	}
 
 	stmt, err := s.Db.PrepareContext(ctx, query)
@@ -41,18 +60,30 @@ func (s *SQLite) CreatePlayer(ctx context.Context, player models.Player) error {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx,
+	res, err := stmt.ExecContext(ctx,
 		player.ID,
 		player.MMR,
 		player.Ping,
 		player.Region,
 		tier,
+		latency,
+		player.Mode,
 		time.Unix(player.JoinedAt, 0),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %s", databases.ErrPlayerAlreadyQueued, player.ID)
+	}
+	return nil
 }
 
-func (s *SQLite) CreateMatch(ctx context.Context, match models.Match) error {
+// CreateMatch stores the match and the outbox messages for its side effects in one
+// transaction. Fails with databases.ErrPlayerNotWaiting if any player is no longer waiting.
+func (s *SQLite) CreateMatch(ctx context.Context, match models.Match, outbox ...models.OutboxMessage) error {
 	/*
 		We need this to be atomic. If match is created but players are not inserted, then rollback
 		else commit
@@ -64,14 +95,26 @@ func (s *SQLite) CreateMatch(ctx context.Context, match models.Match) error {
 	defer tx.Rollback() // if not committed, rollback
 
 	// Insert Match
-	matchQuery := `INSERT INTO matches (id, created_at) VALUES (?, CURRENT_TIMESTAMP)`
-	if _, err := tx.ExecContext(ctx, matchQuery, match.ID); err != nil {
+	var lobby, track sql.NullString
+	var laps sql.NullInt64
+	if match.Lobby != "" {
+		lobby = sql.NullString{String: match.Lobby, Valid: true}
+	}
+	if match.Track != "" {
+		track = sql.NullString{String: match.Track, Valid: true}
+	}
+	if match.Laps > 0 {
+		laps = sql.NullInt64{Int64: int64(match.Laps), Valid: true}
+	}
+	matchQuery := `INSERT INTO matches (id, region, node_id, endpoint, bot_difficulty, rules_version, cross_region, mode, lobby_code, track, laps, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	if _, err := tx.ExecContext(ctx, matchQuery, match.ID, match.Region, match.NodeID, match.Endpoint, match.BotDifficulty, match.RulesVersion, match.CrossRegion, match.Mode, lobby, track, laps); err != nil {
 		return err
 	}
 
 	// Insert Match Players
-	playerQuery := `INSERT INTO matches_players (match_id, player_id) VALUES (?, ?)`
-	statusQuery := `UPDATE players SET status = 'matched' WHERE id = ?`
+	playerQuery := `INSERT INTO matches_players (match_id, player_id, is_bot, home_region, ping, team) VALUES (?, ?, ?, ?, ?, ?)`
+	statusQuery := `UPDATE players SET status = 'matched' WHERE id = ? AND status = 'waiting'`
 
 	stmt, err := tx.PrepareContext(ctx, playerQuery)
 	if err != nil {
@@ -86,10 +129,36 @@ func (s *SQLite) CreateMatch(ctx context.Context, match models.Match) error {
 	defer statusStmt.Close()
 
 	for _, playerID := range match.Players {
-		if _, err := stmt.ExecContext(ctx, match.ID, playerID); err != nil {
+		isBot := match.IsBot(playerID)
+		var homeRegion sql.NullString
+		var ping sql.NullInt64
+		if p, ok := match.Placement(playerID); ok {
+			homeRegion = sql.NullString{String: p.HomeRegion, Valid: true}
+			ping = sql.NullInt64{Int64: int64(p.Ping), Valid: true}
+		}
+		var team sql.NullInt64
+		if t := match.Team(playerID); t >= 0 {
+			team = sql.NullInt64{Int64: int64(t), Valid: true}
+		}
+		if _, err := stmt.ExecContext(ctx, match.ID, playerID, isBot, homeRegion, ping, team); err != nil {
+			return err
+		}
+		if isBot || match.Lobby != "" {
+			continue // bots and lobby players never queued, so have no row in players
+		}
+		res, err := statusStmt.ExecContext(ctx, playerID)
+		if err != nil {
 			return err
 		}
-		if _, err := statusStmt.ExecContext(ctx, playerID); err != nil {
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("%w: %s", databases.ErrPlayerNotWaiting, playerID)
+		}
+	}
+
+	for _, msg := range outbox {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO outbox (kind, payload) VALUES (?, ?)`, msg.Kind, string(msg.Payload)); err != nil {
 			return err
 		}
 	}
@@ -105,17 +174,263 @@ func (s *SQLite) GetMatch(ctx context.Context, playerID string) (models.Match, e
 		return models.Match{}, err
 	}
 
-	if status == "waiting" {
-		return models.Match{Status: "waiting"}, nil
+	if status == "waiting" || status == "left" {
+		return models.Match{Status: status}, nil
 	}
 
-	query := `SELECT match_id FROM matches_players WHERE player_id = ?`
+	query := `SELECT m.id, COALESCE(m.region, ''), COALESCE(m.node_id, ''), COALESCE(m.endpoint, ''), COALESCE(m.mode, '')
+		FROM matches_players mp JOIN matches m ON m.id = mp.match_id
+		WHERE mp.player_id = ?`
 	row := s.Db.QueryRowContext(ctx, query, playerID)
-	var matchID string
-	if err := row.Scan(&matchID); err != nil {
+	match := models.Match{Status: "matched"}
+	if err := row.Scan(&match.ID, &match.Region, &match.NodeID, &match.Endpoint, &match.Mode); err != nil {
+		return models.Match{}, err
+	}
+	return match, nil
+}
+
+func (s *SQLite) GetMatchByID(ctx context.Context, matchID string) (models.Match, error) {
+	match := models.Match{ID: matchID}
+
+	query := `SELECT status, COALESCE(region, ''), COALESCE(node_id, ''), COALESCE(endpoint, ''), COALESCE(bot_difficulty, ''), COALESCE(mode, ''),
+		COALESCE(lobby_code, ''), COALESCE(track, ''), COALESCE(laps, 0)
+		FROM matches WHERE id = ?`
+	err := s.Db.QueryRowContext(ctx, query, matchID).Scan(&match.Status, &match.Region, &match.NodeID, &match.Endpoint, &match.BotDifficulty, &match.Mode,
+		&match.Lobby, &match.Track, &match.Laps)
+	if err != nil {
+		return models.Match{}, err
+	}
+
+	rows, err := s.Db.QueryContext(ctx, `SELECT player_id, is_bot, team FROM matches_players WHERE match_id = ?`, matchID)
+	if err != nil {
 		return models.Match{}, err
 	}
-	return models.Match{ID: matchID, Status: "matched"}, nil
+	defer rows.Close()
+
+	for rows.Next() {
+		var playerID string
+		var isBot bool
+		var team sql.NullInt64
+		if err := rows.Scan(&playerID, &isBot, &team); err != nil {
+			return models.Match{}, err
+		}
+		match.Players = append(match.Players, playerID)
+		if isBot {
+			match.Bots = append(match.Bots, playerID)
+		}
+		if team.Valid {
+			for len(match.Teams) <= int(team.Int64) {
+				match.Teams = append(match.Teams, nil)
+			}
+			match.Teams[team.Int64] = append(match.Teams[team.Int64], playerID)
+		}
+	}
+	return match, rows.Err()
+}
+
+// EndMatch records how a match ended along with every participant's result
+func (s *SQLite) EndMatch(ctx context.Context, matchID, status string, results []models.PlayerResult) error {
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE matches SET status = ?, ended_at = CURRENT_TIMESTAMP WHERE id = ?`, status, matchID); err != nil {
+		return err
+	}
+
+	resultQuery := `INSERT OR REPLACE INTO match_results (match_id, player_id, is_bot, tick, x, y, damaged, partial, ranked,
+		position, lap_times, best_lap, finished, race_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.PrepareContext(ctx, resultQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	partial := status != models.MatchStatusFinished
+	for _, r := range results {
+		// race columns stay NULL for matches without a race
+		var position, bestLap, raceTime sql.NullInt64
+		var lapTimes sql.NullString
+		if r.Position > 0 {
+			position = sql.NullInt64{Int64: int64(r.Position), Valid: true}
+			b, err := json.Marshal(r.LapTimes)
+			if err != nil {
+				return err
+			}
+			lapTimes = sql.NullString{String: string(b), Valid: true}
+		}
+		if r.BestLap > 0 {
+			bestLap = sql.NullInt64{Int64: r.BestLap, Valid: true}
+		}
+		if r.Finished {
+			raceTime = sql.NullInt64{Int64: r.RaceTime, Valid: true}
+		}
+		if _, err := stmt.ExecContext(ctx, matchID, r.PlayerID, r.Bot, r.Tick, r.X, r.Y, r.Damaged, partial, r.Ranked,
+			position, lapTimes, bestLap, r.Finished, raceTime); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetWaitingPlayers returns every player that joined the queue and hasn't been matched yet
+func (s *SQLite) GetWaitingPlayers(ctx context.Context) ([]models.Player, error) {
+	rows, err := s.Db.QueryContext(ctx, `SELECT id, mmr, region, ping, latency, COALESCE(mode, ''), created_at FROM players WHERE status = 'waiting'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var players []models.Player
+	for rows.Next() {
+		var p models.Player
+		var joinedAt time.Time
+		var latency sql.NullString
+		if err := rows.Scan(&p.ID, &p.MMR, &p.Region, &p.Ping, &latency, &p.Mode, &joinedAt); err != nil {
+			return nil, err
+		}
+		if latency.Valid {
+			if err := json.Unmarshal([]byte(latency.String), &p.Latency); err != nil {
+				return nil, err
+			}
+		}
+		p.JoinedAt = joinedAt.Unix()
+		players = append(players, p)
+	}
+	return players, rows.Err()
+}
+
+func (s *SQLite) MarkPlayersWaiting(ctx context.Context, playerIDs []string) error {
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE players SET status = 'waiting' WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range playerIDs {
+		if _, err := stmt.ExecContext(ctx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MarkPlayerLeft records that a waiting player left the queue, so RestoreQueues skips them.
+// Returns sql.ErrNoRows if the player isn't waiting.
+func (s *SQLite) MarkPlayerLeft(ctx context.Context, playerID string) error {
+	res, err := s.Db.ExecContext(ctx, `UPDATE players SET status = 'left' WHERE id = ? AND status = 'waiting'`, playerID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *SQLite) CreateReplay(ctx context.Context, replay models.Replay) error {
+	// a match is only ever recorded once, a second recording must not replace the first
+	query := `INSERT INTO replays (match_id, path, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`
+	_, err := s.Db.ExecContext(ctx, query, replay.MatchID, replay.Path)
+	return err
+}
+
+func (s *SQLite) GetReplay(ctx context.Context, matchID string) (models.Replay, error) {
+	var replay models.Replay
+	query := `SELECT match_id, path, created_at FROM replays WHERE match_id = ?`
+	err := s.Db.QueryRowContext(ctx, query, matchID).Scan(&replay.MatchID, &replay.Path, &replay.CreatedAt)
+	return replay, err
+}
+
+func (s *SQLite) CreateCheatIncident(ctx context.Context, incident models.CheatIncident) error {
+	violations, err := json.Marshal(incident.Violations)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO cheat_incidents (match_id, player_id, tick, action, score, violations, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	_, err = s.Db.ExecContext(ctx, query,
+		incident.MatchID,
+		incident.PlayerID,
+		incident.Tick,
+		incident.Action,
+		incident.Score,
+		string(violations),
+		sql.NullString{String: incident.Detail, Valid: incident.Detail != ""},
+	)
+	return err
+}
+
+// GetCheatIncidents returns the latest incidents, for one player or everyone when playerID is empty
+func (s *SQLite) GetCheatIncidents(ctx context.Context, playerID string, limit int) ([]models.CheatIncident, error) {
+	query := `SELECT id, match_id, player_id, tick, action, score, violations, detail, created_at
+		FROM cheat_incidents WHERE ? = '' OR player_id = ? ORDER BY id DESC LIMIT ?`
+	rows, err := s.Db.QueryContext(ctx, query, playerID, playerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var incidents []models.CheatIncident
+	for rows.Next() {
+		var incident models.CheatIncident
+		var violations string
+		var detail sql.NullString
+		if err := rows.Scan(&incident.ID, &incident.MatchID, &incident.PlayerID, &incident.Tick, &incident.Action,
+			&incident.Score, &violations, &detail, &incident.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(violations), &incident.Violations); err != nil {
+			return nil, err
+		}
+		incident.Detail = detail.String
+		incidents = append(incidents, incident)
+	}
+	return incidents, rows.Err()
+}
+
+// PendingOutbox returns unprocessed outbox messages, oldest first
+func (s *SQLite) PendingOutbox(ctx context.Context, limit int) ([]models.OutboxMessage, error) {
+	query := `SELECT id, kind, payload, attempts, created_at FROM outbox WHERE processed_at IS NULL ORDER BY id LIMIT ?`
+	rows, err := s.Db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.OutboxMessage
+	for rows.Next() {
+		var msg models.OutboxMessage
+		var payload string
+		if err := rows.Scan(&msg.ID, &msg.Kind, &payload, &msg.Attempts, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		msg.Payload = []byte(payload)
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *SQLite) MarkOutboxDone(ctx context.Context, id int64) error {
+	_, err := s.Db.ExecContext(ctx, `UPDATE outbox SET processed_at = CURRENT_TIMESTAMP, attempts = attempts + 1 WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLite) MarkOutboxFailed(ctx context.Context, id int64, reason string) error {
+	_, err := s.Db.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?`, reason, id)
+	return err
 }
 
 func (s *SQLite) ClearTables(ctx context.Context) error {
@@ -125,7 +440,7 @@ func (s *SQLite) ClearTables(ctx context.Context) error {
 	}
 	defer tx.Rollback()
 
-	tables := []string{"matches_players", "matches", "players"} // Order matters due to FKs if any
+	tables := []string{"cheat_incidents", "outbox", "match_results", "replays", "matches_players", "matches", "players"} // Order matters due to FKs if any
 	for _, table := range tables {
 		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
 			return err
@@ -134,3 +449,15 @@ func (s *SQLite) ClearTables(ctx context.Context) error {
 
 	return tx.Commit()
 }
+
+func (s *SQLite) Ping(ctx context.Context) error {
+	return s.Db.PingContext(ctx)
+}
+
+// SchemaVersion returns the latest migration goose has applied
+func (s *SQLite) SchemaVersion(ctx context.Context) (int64, error) {
+	var version int64
+	query := `SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version WHERE is_applied = 1`
+	err := s.Db.QueryRowContext(ctx, query).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Consumer reads a stream as one member of a consumer group. Entries stay
+// pending until acknowledged, and entries another consumer left pending for
+// longer than ClaimAfter are taken over.
+type Consumer struct {
+	Stream     *Stream
+	Group      string
+	Name       string
+	ClaimAfter time.Duration
+	Block      time.Duration // how long Read waits for new entries
+
+	lastClaim time.Time
+}
+
+// EnsureGroup creates the consumer group, and the stream with it, if they don't exist yet.
+// A new group starts at the end of the stream.
+func (c *Consumer) EnsureGroup(ctx context.Context) error {
+	err := c.Stream.Client.XGroupCreateMkStream(ctx, c.Stream.Name, c.Group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// Read returns the next batch of events for this consumer. Entries that could
+// not be decoded are acknowledged and dropped.
+func (c *Consumer) Read(ctx context.Context, count int64) ([]Event, error) {
+	var msgs []redis.XMessage
+
+	if time.Since(c.lastClaim) >= c.ClaimAfter {
+		c.lastClaim = time.Now()
+		claimed, _, err := c.Stream.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.Stream.Name,
+			Group:    c.Group,
+			Consumer: c.Name,
+			MinIdle:  c.ClaimAfter,
+			Start:    "0-0",
+			Count:    count,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		msgs = claimed
+	}
+
+	if len(msgs) == 0 {
+		streams, err := c.Stream.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.Group,
+			Consumer: c.Name,
+			Streams:  []string{c.Stream.Name, ">"},
+			Count:    count,
+			Block:    c.Block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil // nothing new within Block
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range streams {
+			msgs = append(msgs, s.Messages...)
+		}
+	}
+
+	out := make([]Event, 0, len(msgs))
+	var bad []string
+	for _, msg := range msgs {
+		ev, ok := decode(msg)
+		if !ok {
+			bad = append(bad, msg.ID)
+			continue
+		}
+		out = append(out, ev)
+	}
+	if len(bad) > 0 {
+		if err := c.Ack(ctx, bad...); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Ack marks events as handled. They stay in the stream as part of the audit log.
+func (c *Consumer) Ack(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return c.Stream.Client.XAck(ctx, c.Stream.Name, c.Group, ids...).Err()
+}
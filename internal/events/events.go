@@ -0,0 +1,94 @@
+// Package events records matchmaking activity in a Redis Stream. Matchmaker
+// replicas read it through a consumer group, and the stream is kept as an
+// audit log that can be replayed into analytics.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	QueueJoined  = "queue_joined"
+	QueueLeft    = "queue_left"
+	MatchCreated = "match_created"
+	MatchFailed  = "match_failed"
+)
+
+type Event struct {
+	ID       string   `json:"id,omitempty"` // stream entry ID, set when read back
+	Type     string   `json:"type"`
+	At       int64    `json:"at"` // unix millis
+	PlayerID string   `json:"player_id,omitempty"`
+	Players  []string `json:"players,omitempty"`
+	MatchID  string   `json:"match_id,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Region   string   `json:"region,omitempty"`
+	Tier     string   `json:"tier,omitempty"`
+	NodeID   string   `json:"node_id,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	OutboxID int64    `json:"outbox_id,omitempty"` // set on events relayed from the outbox, a retry can deliver one twice
+}
+
+// Stream appends events to a Redis Stream
+type Stream struct {
+	Client *redis.Client
+	Name   string
+	MaxLen int64 // approximate number of entries kept, 0 keeps everything
+}
+
+func (s *Stream) Publish(ctx context.Context, ev Event) error {
+	if ev.At == 0 {
+		ev.At = time.Now().UnixMilli()
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	args := &redis.XAddArgs{
+		Stream: s.Name,
+		Values: map[string]any{"type": ev.Type, "data": data},
+	}
+	if s.MaxLen > 0 {
+		args.MaxLen = s.MaxLen
+		args.Approx = true
+	}
+	return s.Client.XAdd(ctx, args).Err()
+}
+
+// Range returns events with IDs between start and end, inclusive.
+// Use "-" and "+" for the beginning and end of the stream.
+func (s *Stream) Range(ctx context.Context, start, end string, count int64) ([]Event, error) {
+	msgs, err := s.Client.XRangeN(ctx, s.Name, start, end, count).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(msgs), nil
+}
+
+func decode(msg redis.XMessage) (Event, bool) {
+	data, ok := msg.Values["data"].(string)
+	if !ok {
+		return Event{}, false
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		return Event{}, false
+	}
+	ev.ID = msg.ID
+	return ev, true
+}
+
+func decodeAll(msgs []redis.XMessage) []Event {
+	out := make([]Event, 0, len(msgs))
+	for _, msg := range msgs {
+		if ev, ok := decode(msg); ok {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/http/handlers/matchmaking"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+)
+
+// GetMatchmakingRules returns the rules the matchmaker is running with
+func GetMatchmakingRules(rules *matchmaking.RuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: response.StatusOK,
+			Data:   rules.Current(),
+		})
+	}
+}
+
+// UpdateMatchmakingRules replaces the matchmaking rules. The body is the full rule
+// set, the matchmaker picks it up on its next pass.
+func UpdateMatchmakingRules(rules *matchmaking.RuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var next config.Matchmaking
+		if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
+			return
+		}
+
+		set, err := rules.Apply(r.Context(), next, "admin")
+		if err != nil {
+			logger.FromContext(r.Context()).Warn("Rejected matchmaking rules", slog.String("error", err.Error()))
+			response.WriteJson(w, http.StatusUnprocessableEntity, response.GeneralError(err))
+			return
+		}
+
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: response.StatusOK,
+			Data:   set,
+		})
+	}
+}
+
+// maxIncidents caps how many cheat incidents one request can list
+const maxIncidents = 1000
+
+// ListCheatIncidents returns the latest anti-cheat incidents for review, newest
+// first. ?player_id= narrows them to one player, ?limit= defaults to 100.
+func ListCheatIncidents(db databases.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if s := r.URL.Query().Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxIncidents {
+				response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("limit must be between 1 and %d", maxIncidents)))
+				return
+			}
+		}
+
+		incidents, err := db.GetCheatIncidents(r.Context(), r.URL.Query().Get("player_id"), limit)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("Failed to list cheat incidents", slog.String("error", err.Error()))
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
+			return
+		}
+
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: response.StatusOK,
+			Data:   incidents,
+		})
+	}
+}
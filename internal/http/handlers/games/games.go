@@ -0,0 +1,62 @@
+package games
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/socket"
+)
+
+type addBotsRequest struct {
+	Count      int    `json:"count"`
+	Difficulty string `json:"difficulty"`
+}
+
+// AddBots puts server-driven players into a live match's game, starting the game if
+// it isn't running. Used to fill matches by hand and to simulate load.
+func AddBots(gm *socket.GameManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req addBotsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
+			return
+		}
+		if req.Count < 1 || req.Count > config.MaxMatchSize {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("count must be between 1 and %d", config.MaxMatchSize)))
+			return
+		}
+		if req.Difficulty == "" {
+			req.Difficulty = socket.BotMedium
+		}
+		if !socket.ValidBotDifficulty(req.Difficulty) {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("unknown difficulty %q", req.Difficulty)))
+			return
+		}
+
+		matchID := r.PathValue("id")
+		game, err := gm.CreateGame(matchID)
+		switch {
+		case errors.Is(err, socket.ErrMatchNotFound), errors.Is(err, socket.ErrMatchOver):
+			response.WriteJson(w, http.StatusNotFound, response.GeneralError(fmt.Errorf("no live match %s", matchID)))
+			return
+		case err != nil:
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
+			return
+		}
+
+		bots, err := game.AddBots(req.Count, req.Difficulty, config.MaxMatchSize)
+		if errors.Is(err, socket.ErrGameFull) {
+			response.WriteJson(w, http.StatusConflict, response.GeneralError(fmt.Errorf("a match holds at most %d players", config.MaxMatchSize)))
+			return
+		}
+
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: response.StatusOK,
+			Data:   bots,
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/socket"
+)
+
+const checkTimeout = 2 * time.Second
+
+type check func(ctx context.Context) error
+
+// Checker backs /healthz and /readyz.
+// Liveness only looks at this process' own loops, readiness also checks its dependencies.
+type Checker struct {
+	DB                databases.Database
+	Hub               *socket.Hub
+	SchemaVersion     int64       // minimum migration version the code needs
+	MatchmakerRunning func() bool // reports whether the matchmaker goroutine is alive
+
+	shuttingDown atomic.Bool
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// SetShuttingDown makes readiness fail so load balancers stop sending traffic
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+func (c *Checker) ShuttingDown() bool {
+	return c.shuttingDown.Load()
+}
+
+func (c *Checker) checkRedis(ctx context.Context) error {
+	rdb := utils.GetClient()
+	if rdb == nil {
+		return fmt.Errorf("redis client is nil")
+	}
+	return rdb.Ping(ctx).Err()
+}
+
+func (c *Checker) checkDB(ctx context.Context) error {
+	return c.DB.Ping(ctx)
+}
+
+func (c *Checker) checkSchema(ctx context.Context) error {
+	version, err := c.DB.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < c.SchemaVersion {
+		return fmt.Errorf("schema version %d, want %d", version, c.SchemaVersion)
+	}
+	return nil
+}
+
+func (c *Checker) checkMatchmaker(ctx context.Context) error {
+	// the matchmaker is stopped on purpose while draining
+	if !c.MatchmakerRunning() && !c.shuttingDown.Load() {
+		return fmt.Errorf("matchmaker is not running")
+	}
+	return nil
+}
+
+func (c *Checker) checkHub(ctx context.Context) error {
+	return c.Hub.Ping(ctx)
+}
+
+func (c *Checker) checkShutdown(ctx context.Context) error {
+	if c.shuttingDown.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+// Liveness fails only when the process is wedged and should be restarted
+func (c *Checker) Liveness() http.HandlerFunc {
+	return c.handler(map[string]check{
+		"matchmaker": c.checkMatchmaker,
+		"hub":        c.checkHub,
+	})
+}
+
+// Readiness fails whenever the node should not get new traffic
+func (c *Checker) Readiness() http.HandlerFunc {
+	return c.handler(map[string]check{
+		"shutdown":   c.checkShutdown,
+		"redis":      c.checkRedis,
+		"db":         c.checkDB,
+		"schema":     c.checkSchema,
+		"matchmaker": c.checkMatchmaker,
+		"hub":        c.checkHub,
+	})
+}
+
+func (c *Checker) handler(checks map[string]check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		res := report{Status: response.StatusOK, Checks: make(map[string]string, len(checks))}
+		status := http.StatusOK
+		for name, fn := range checks {
+			if err := fn(ctx); err != nil {
+				res.Checks[name] = err.Error()
+				res.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			res.Checks[name] = response.StatusOK
+		}
+
+		response.WriteJson(w, status, res)
+	}
+}
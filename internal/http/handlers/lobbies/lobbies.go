@@ -0,0 +1,360 @@
+package lobbies
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/allocator"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/socket"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	errLobbyNotFound    = errors.New("lobby not found")
+	errLobbyFull        = errors.New("lobby is full")
+	errLobbyStarted     = errors.New("lobby has already started")
+	errLobbyBusy        = errors.New("lobby is busy, try again")
+	errNotHost          = errors.New("only the host can do that")
+	errNotInLobby       = errors.New("player is not in the lobby")
+	errKicked           = errors.New("player was kicked from the lobby")
+	errNotEnoughPlayers = errors.New("a lobby needs at least two players to start")
+)
+
+// Handler serves private lobbies. Lobbies live in Redis so any node can take joins,
+// the node that handles the start hosts the match.
+type Handler struct {
+	Redis  *redis.Client
+	DB     databases.Database
+	Alloc  *allocator.Allocator
+	Games  *socket.GameManager
+	Tracks *tracks.Catalog
+	Node   allocator.Node // this node
+	Config config.Lobbies
+}
+
+type createRequest struct {
+	PlayerID   string `json:"player_id"`
+	Track      string `json:"track"`
+	Laps       int    `json:"laps"`
+	MaxPlayers int    `json:"max_players"`
+}
+
+type playerRequest struct {
+	PlayerID string `json:"player_id"`
+}
+
+type kickRequest struct {
+	PlayerID string `json:"player_id"` // the host
+	TargetID string `json:"target_id"`
+}
+
+// Create opens a lobby hosted by the requesting player. Settings left out take the
+// configured defaults, the default track and its lap count.
+func (h *Handler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
+			return
+		}
+		if req.PlayerID == "" {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("player_id is required")))
+			return
+		}
+
+		track, ok := h.Tracks.Get(req.Track)
+		if !ok {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("unknown track %q, available: %v", req.Track, h.Tracks.Names())))
+			return
+		}
+		settings := models.LobbySettings{Track: track.Name, Laps: req.Laps, MaxPlayers: req.MaxPlayers}
+		if settings.Laps == 0 {
+			settings.Laps = track.Laps
+		}
+		if settings.MaxPlayers == 0 {
+			settings.MaxPlayers = h.Config.DefaultMaxPlayers
+		}
+		if settings.Laps < 1 || settings.Laps > h.Config.MaxLaps {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("laps must be between 1 and %d", h.Config.MaxLaps)))
+			return
+		}
+		if settings.MaxPlayers < 2 || settings.MaxPlayers > config.MaxMatchSize {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("max_players must be between 2 and %d", config.MaxMatchSize)))
+			return
+		}
+
+		lobby := models.Lobby{
+			Host:      req.PlayerID,
+			Settings:  settings,
+			Players:   []string{req.PlayerID},
+			Status:    models.LobbyStatusOpen,
+			CreatedAt: time.Now().Unix(),
+		}
+		if err := h.create(r.Context(), &lobby); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		logger.FromContext(r.Context()).Info("Lobby created",
+			slog.String("lobby", lobby.Code),
+			slog.String("host", lobby.Host),
+			slog.String("track", settings.Track),
+			slog.Int("laps", settings.Laps),
+			slog.Int("max_players", settings.MaxPlayers),
+		)
+		response.WriteJson(w, http.StatusCreated, response.SuccessResponse{Status: response.StatusOK, Data: lobby})
+	}
+}
+
+// Get returns a lobby, players poll it to learn when the match has started
+func (h *Handler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lobby, err := h.get(r.Context(), r.PathValue("code"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{Status: response.StatusOK, Data: lobby})
+	}
+}
+
+// Join adds a player to an open lobby. Joining a lobby you are already in is a no-op.
+func (h *Handler) Join() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req playerRequest
+		if !decodePlayer(w, r, &req) {
+			return
+		}
+
+		lobby, err := h.update(r.Context(), r.PathValue("code"), func(lobby *models.Lobby) error {
+			switch {
+			case lobby.Status != models.LobbyStatusOpen:
+				return errLobbyStarted
+			case lobby.WasKicked(req.PlayerID):
+				return errKicked
+			case lobby.Has(req.PlayerID):
+				return nil
+			case len(lobby.Players) >= lobby.Settings.MaxPlayers:
+				return errLobbyFull
+			}
+			lobby.Players = append(lobby.Players, req.PlayerID)
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		logger.FromContext(r.Context()).Info("Player joined lobby", slog.String("lobby", lobby.Code), slog.String("player_id", req.PlayerID))
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{Status: response.StatusOK, Data: lobby})
+	}
+}
+
+// Leave takes a player out of an open lobby. If the host leaves, the longest
+// present player takes over.
+func (h *Handler) Leave() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req playerRequest
+		if !decodePlayer(w, r, &req) {
+			return
+		}
+
+		lobby, err := h.update(r.Context(), r.PathValue("code"), func(lobby *models.Lobby) error {
+			if lobby.Status != models.LobbyStatusOpen {
+				return errLobbyStarted
+			}
+			if !lobby.Has(req.PlayerID) {
+				return errNotInLobby
+			}
+			lobby.Players = without(lobby.Players, req.PlayerID)
+			if lobby.Host == req.PlayerID && len(lobby.Players) > 0 {
+				lobby.Host = lobby.Players[0]
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		logger.FromContext(r.Context()).Info("Player left lobby", slog.String("lobby", lobby.Code), slog.String("player_id", req.PlayerID))
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{Status: response.StatusOK, Data: lobby})
+	}
+}
+
+// Kick lets the host remove a player, who can't join the lobby again
+func (h *Handler) Kick() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req kickRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
+			return
+		}
+		if req.TargetID == "" || req.TargetID == req.PlayerID {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("target_id must name another player")))
+			return
+		}
+
+		lobby, err := h.update(r.Context(), r.PathValue("code"), func(lobby *models.Lobby) error {
+			switch {
+			case lobby.Host != req.PlayerID:
+				return errNotHost
+			case lobby.Status != models.LobbyStatusOpen:
+				return errLobbyStarted
+			case !lobby.Has(req.TargetID):
+				return errNotInLobby
+			}
+			lobby.Players = without(lobby.Players, req.TargetID)
+			lobby.Kicked = append(lobby.Kicked, req.TargetID)
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		logger.FromContext(r.Context()).Info("Player kicked from lobby", slog.String("lobby", lobby.Code), slog.String("player_id", req.TargetID))
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{Status: response.StatusOK, Data: lobby})
+	}
+}
+
+// Start turns the lobby into a match hosted on this node. The lobby is claimed
+// first so two starts can't both create a match; if the match can't be set up
+// the lobby opens again.
+func (h *Handler) Start() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req playerRequest
+		if !decodePlayer(w, r, &req) {
+			return
+		}
+		code := r.PathValue("code")
+
+		lobby, err := h.update(r.Context(), code, func(lobby *models.Lobby) error {
+			switch {
+			case lobby.Host != req.PlayerID:
+				return errNotHost
+			case lobby.Status != models.LobbyStatusOpen:
+				return errLobbyStarted
+			case len(lobby.Players) < 2:
+				return errNotEnoughPlayers
+			}
+			lobby.Status = models.LobbyStatusStarting
+			lobby.MatchID = fmt.Sprintf("lobby-%s-%d", lobby.Code, time.Now().Unix())
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ctx := logger.With(r.Context(), slog.String("lobby", code), slog.String("match_id", lobby.MatchID))
+		log := logger.FromContext(ctx)
+
+		match, err := h.startMatch(ctx, lobby)
+		if err != nil {
+			log.Error("Failed to start lobby match", slog.String("error", err.Error()))
+			if _, reopenErr := h.update(ctx, code, func(l *models.Lobby) error {
+				l.Status, l.MatchID = models.LobbyStatusOpen, ""
+				return nil
+			}); reopenErr != nil {
+				log.Error("Failed to reopen lobby", slog.String("error", reopenErr.Error()))
+			}
+			writeError(w, err)
+			return
+		}
+
+		lobby, err = h.update(ctx, code, func(l *models.Lobby) error {
+			l.Status, l.Endpoint = models.LobbyStatusStarted, match.Endpoint
+			return nil
+		})
+		if err != nil {
+			// the match is running, players who already got the code from the host can still join it
+			log.Warn("Failed to mark lobby started", slog.String("error", err.Error()))
+			lobby.Status, lobby.Endpoint = models.LobbyStatusStarted, match.Endpoint
+		}
+
+		log.Info("Lobby started", slog.Any("player_ids", match.Players), slog.String("node_id", match.NodeID))
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{Status: response.StatusOK, Data: lobby})
+	}
+}
+
+// startMatch reserves a slot on this node, records the match and starts its game.
+// Lobby matches skip the queue entirely and are never ranked.
+func (h *Handler) startMatch(ctx context.Context, lobby models.Lobby) (models.Match, error) {
+	match := models.Match{
+		ID:      lobby.MatchID,
+		Players: lobby.Players,
+		Region:  h.Node.Region,
+		Lobby:   lobby.Code,
+		Track:   lobby.Settings.Track,
+		Laps:    lobby.Settings.Laps,
+	}
+
+	allocation, err := h.Alloc.Reserve(ctx, h.Node.ID, match.ID)
+	if err != nil {
+		return match, err
+	}
+	match.NodeID = allocation.NodeID
+	match.Endpoint = allocation.Endpoint
+
+	if err := h.DB.CreateMatch(ctx, match); err != nil {
+		h.Alloc.Release(ctx, match.NodeID, match.ID)
+		return match, err
+	}
+
+	if _, err := h.Games.CreateGame(match.ID); err != nil {
+		// nobody can play it, so don't leave it looking live
+		h.DB.EndMatch(ctx, match.ID, models.MatchStatusAborted, nil)
+		h.Alloc.Release(ctx, match.NodeID, match.ID)
+		return match, err
+	}
+	return match, nil
+}
+
+func decodePlayer(w http.ResponseWriter, r *http.Request, req *playerRequest) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
+		return false
+	}
+	if req.PlayerID == "" {
+		response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("player_id is required")))
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errLobbyNotFound), errors.Is(err, errNotInLobby):
+		status = http.StatusNotFound
+	case errors.Is(err, errNotHost), errors.Is(err, errKicked):
+		status = http.StatusForbidden
+	case errors.Is(err, errLobbyFull), errors.Is(err, errLobbyStarted), errors.Is(err, errLobbyBusy), errors.Is(err, errNotEnoughPlayers):
+		status = http.StatusConflict
+	case errors.Is(err, allocator.ErrNoNodeAvailable):
+		status = http.StatusServiceUnavailable
+	}
+	response.WriteJson(w, status, response.GeneralError(err))
+}
+
+func without(ids []string, id string) []string {
+	out := make([]string, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package lobbies
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+Redis layout:
+
+	lobby:<code>  STRING the lobby as JSON, expires once nobody has touched it for the idle timeout
+*/
+
+// codeAlphabet leaves out characters that are easy to misread when a code is shared out loud
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// updateAttempts bounds how often an update is retried when another request changed the lobby first
+const updateAttempts = 5
+
+func lobbyKey(code string) string {
+	return fmt.Sprintf("lobby:%s", code)
+}
+
+func newCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = codeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// create stores a new lobby under a code no other lobby is using and sets lobby.Code
+func (h *Handler) create(ctx context.Context, lobby *models.Lobby) error {
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		code, err := newCode(h.Config.CodeLength)
+		if err != nil {
+			return err
+		}
+		lobby.Code = code
+
+		data, err := json.Marshal(lobby)
+		if err != nil {
+			return err
+		}
+		ok, err := h.Redis.SetNX(ctx, lobbyKey(code), data, h.Config.IdleTimeout).Result()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		// code taken, draw another
+	}
+	return errors.New("no free lobby code, try again")
+}
+
+func (h *Handler) get(ctx context.Context, code string) (models.Lobby, error) {
+	var lobby models.Lobby
+	data, err := h.Redis.Get(ctx, lobbyKey(code)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return lobby, errLobbyNotFound
+	}
+	if err != nil {
+		return lobby, err
+	}
+	return lobby, json.Unmarshal(data, &lobby)
+}
+
+// update applies fn to the lobby and saves the result, unless fn fails. Concurrent
+// updates are serialised with WATCH, the loser runs fn again on the new state. A
+// lobby everyone has left is dropped.
+func (h *Handler) update(ctx context.Context, code string, fn func(lobby *models.Lobby) error) (models.Lobby, error) {
+	key := lobbyKey(code)
+
+	var lobby models.Lobby
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return errLobbyNotFound
+		}
+		if err != nil {
+			return err
+		}
+		lobby = models.Lobby{}
+		if err := json.Unmarshal(data, &lobby); err != nil {
+			return err
+		}
+		if err := fn(&lobby); err != nil {
+			return err
+		}
+
+		data, err = json.Marshal(lobby)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(lobby.Players) == 0 {
+				pipe.Del(ctx, key)
+			} else {
+				pipe.Set(ctx, key, data, h.Config.IdleTimeout)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		err := h.Redis.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return lobby, err
+	}
+	return lobby, errLobbyBusy
+}
@@ -2,45 +2,83 @@ package matchmaking
 
 import (
 	"fmt"
+	"log/slog"
 	"math"
+	"strings"
+	"time"
 
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
 )
 
-func CanMatch(p1, p2 models.Player) bool {
+// CanMatch reports whether two players may be paired. It is symmetric: the order
+// the players come in doesn't matter.
+func CanMatch(p1, p2 models.Player, rules config.Matchmaking) bool {
+	log := slog.With(slog.String("player_id", p1.ID), slog.String("opponent_id", p2.ID))
+
 	if p1.Region != p2.Region {
-		fmt.Println("Players are from different regions")
+		log.Debug("Players are from different regions")
 		return false
 	}
 
 	mmrGap := math.Abs(float64(p1.MMR - p2.MMR))
-	if mmrGap > 100 {
-		fmt.Println("Players have a MMR gap of ", mmrGap)
+	if mmrGap > float64(rules.MaxMMRGap) {
+		log.Debug("Players have a large MMR gap", slog.Float64("mmr_gap", mmrGap))
+		return false
+	}
+
+	if p1.Ping > rules.MaxPing || p2.Ping > rules.MaxPing {
+		log.Debug("A player's ping is too high", slog.Int("ping", p1.Ping), slog.Int("opponent_ping", p2.Ping))
 		return false
 	}
 
-	if p1.Ping > p2.Ping {
-		fmt.Println("Player 1 has a higher ping than player 2")
+	pingDiff := abs(p1.Ping - p2.Ping)
+	if pingDiff > rules.MaxPingDiff {
+		log.Debug("Players have a large ping difference", slog.Int("ping_diff", pingDiff))
 		return false
 	}
 
-	fmt.Printf("[Match ✅] %s vs %s | MMR Gap: %0.f | Region: %s\n", p1.ID, p2.ID, mmrGap, p1.Region)
+	log.Debug("Players can match", slog.Float64("mmr_gap", mmrGap), slog.Int("ping_diff", pingDiff), slog.String("region", p1.Region))
 	return true
 }
 
-func GetTier(mmr int) string {
-	if mmr < 500 {
-		return "newbie"
-	}
-	if mmr < 700 {
-		return "specialist"
+// MatchQuality scores a compatible pair from 0 to 1, higher is better. A close MMR
+// and low, even pings make a good pair, and the longer the longest waiting player
+// has waited the more a pair is worth taking.
+func MatchQuality(p1, p2 models.Player, rules config.Matchmaking, now time.Time) float64 {
+	q := rules.Quality
+
+	mmr := 1 - math.Min(math.Abs(float64(p1.MMR-p2.MMR))/float64(rules.MaxMMRGap), 1)
+
+	worst := float64(max(p1.Ping, p2.Ping)) / float64(rules.MaxPing)
+	diff := 0.0
+	if rules.MaxPingDiff > 0 {
+		diff = float64(abs(p1.Ping-p2.Ping)) / float64(rules.MaxPingDiff)
 	}
-	if mmr < 900 {
-		return "expert"
+	ping := 1 - math.Min((worst+diff)/2, 1)
+
+	waited := now.Sub(time.Unix(min(p1.JoinedAt, p2.JoinedAt), 0))
+	wait := math.Min(float64(waited)/float64(q.WaitHorizon), 1)
+
+	return (q.MMRWeight*mmr + q.PingWeight*ping + q.WaitWeight*wait) / (q.MMRWeight + q.PingWeight + q.WaitWeight)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
 	}
-	return "candidate_master"
+	return n
 }
 
-func GetQueueName(region, tier string) string {
-	return fmt.Sprintf("queue:%s:%s", region, tier)
+func GetQueueName(mode, region, tier string) string {
+	return fmt.Sprintf("queue:%s:%s:%s", mode, region, tier)
+}
+
+// parseQueueName splits a name made by GetQueueName back into its parts
+func parseQueueName(name string) (mode, region, tier string, ok bool) {
+	parts := strings.Split(name, ":")
+	if len(parts) != 4 || parts[0] != "queue" {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
 }
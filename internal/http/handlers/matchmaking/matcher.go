@@ -0,0 +1,281 @@
+package matchmaking
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+)
+
+const (
+	MatcherGreedy  = "greedy"
+	MatcherOptimal = "optimal"
+	MatcherFIFO    = "fifo"
+)
+
+// Proposal pairs two entries of a snapshot, by index
+type Proposal struct {
+	A, B    int
+	Quality float64
+}
+
+// Matcher decides which queued players to pair. It gets a snapshot of one pool,
+// sorted by MMR and with every player's Region and Ping as seen from that pool,
+// and must be deterministic for a given snapshot and now. Every proposal must
+// pass CanMatch and no entry may be used twice.
+type Matcher interface {
+	Name() string
+	Match(players []models.Player, rules config.Matchmaking, now time.Time) []Proposal
+}
+
+// NewMatcher returns the strategy registered under name
+func NewMatcher(name string, rules config.Matchmaking) (Matcher, error) {
+	switch name {
+	case MatcherGreedy:
+		return greedyMatcher{}, nil
+	case MatcherOptimal:
+		return optimalMatcher{window: rules.MatcherWindow}, nil
+	case MatcherFIFO:
+		return fifoMatcher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown matcher %q", name)
+	}
+}
+
+// Matchers returns every strategy, for comparing them
+func Matchers(rules config.Matchmaking) []Matcher {
+	out := make([]Matcher, 0, len(config.MatcherNames))
+	for _, name := range config.MatcherNames {
+		m, _ := NewMatcher(name, rules)
+		out = append(out, m)
+	}
+	return out
+}
+
+// compatiblePairs scores every pair CanMatch allows. Players are sorted by MMR, so
+// partners are only searched until the MMR gap is too large.
+func compatiblePairs(players []models.Player, rules config.Matchmaking, now time.Time) []Proposal {
+	var pairs []Proposal
+	for a := range players {
+		for b := a + 1; b < len(players) && players[b].MMR-players[a].MMR <= rules.MaxMMRGap; b++ {
+			if CanMatch(players[a], players[b], rules) {
+				pairs = append(pairs, Proposal{A: a, B: b, Quality: MatchQuality(players[a], players[b], rules, now)})
+			}
+		}
+	}
+	return pairs
+}
+
+// greedyMatcher takes the best scoring pair left until none remain
+type greedyMatcher struct{}
+
+func (greedyMatcher) Name() string { return MatcherGreedy }
+
+func (greedyMatcher) Match(players []models.Player, rules config.Matchmaking, now time.Time) []Proposal {
+	pairs := compatiblePairs(players, rules, now)
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Quality > pairs[j].Quality })
+
+	used := make([]bool, len(players))
+	var out []Proposal
+	for _, p := range pairs {
+		if used[p.A] || used[p.B] {
+			continue
+		}
+		used[p.A], used[p.B] = true, true
+		out = append(out, p)
+	}
+	return out
+}
+
+// fifoMatcher serves the longest waiting player first, pairing them with their best
+// scoring compatible partner
+type fifoMatcher struct{}
+
+func (fifoMatcher) Name() string { return MatcherFIFO }
+
+func (fifoMatcher) Match(players []models.Player, rules config.Matchmaking, now time.Time) []Proposal {
+	order := make([]int, len(players))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return players[order[i]].JoinedAt < players[order[j]].JoinedAt })
+
+	partners := make([][]Proposal, len(players))
+	for _, p := range compatiblePairs(players, rules, now) {
+		partners[p.A] = append(partners[p.A], p)
+		partners[p.B] = append(partners[p.B], p)
+	}
+
+	used := make([]bool, len(players))
+	var out []Proposal
+	for _, i := range order {
+		if used[i] {
+			continue
+		}
+		best := -1
+		for k, p := range partners[i] {
+			if used[p.A] || used[p.B] {
+				continue
+			}
+			if best < 0 || p.Quality > partners[i][best].Quality {
+				best = k
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		p := partners[i][best]
+		used[p.A], used[p.B] = true, true
+		out = append(out, p)
+	}
+	return out
+}
+
+// optimalMatcher finds the pairing with the highest total quality. Exact matching is
+// exponential, so the MMR-sorted pool is solved a window at a time; players left
+// unpaired in a window who could still pair with the next players are carried over.
+type optimalMatcher struct {
+	window int
+}
+
+func (optimalMatcher) Name() string { return MatcherOptimal }
+
+func (m optimalMatcher) Match(players []models.Player, rules config.Matchmaking, now time.Time) []Proposal {
+	var out []Proposal
+	var carry []int
+	next := 0
+
+	for next < len(players) {
+		window := carry
+		for len(window) < m.window && next < len(players) {
+			window = append(window, next)
+			next++
+		}
+
+		proposals := bestPairing(players, window, rules, now)
+		out = append(out, proposals...)
+
+		if next >= len(players) {
+			break
+		}
+
+		// carry whoever is still unpaired and within reach of the next player
+		paired := make(map[int]bool, 2*len(proposals))
+		for _, p := range proposals {
+			paired[p.A], paired[p.B] = true, true
+		}
+		carry = nil
+		for _, i := range window {
+			if !paired[i] && players[next].MMR-players[i].MMR <= rules.MaxMMRGap {
+				carry = append(carry, i)
+			}
+		}
+		if len(carry) >= m.window {
+			// keep room for new players, those furthest below the next player lose out
+			carry = carry[len(carry)-m.window/2:]
+		}
+	}
+	return out
+}
+
+// bestPairing solves maximum total quality matching over a window of entries with a
+// DP over the subsets of entries already decided
+func bestPairing(players []models.Player, window []int, rules config.Matchmaking, now time.Time) []Proposal {
+	n := len(window)
+	quality := make([][]float64, n)
+	for a := range quality {
+		quality[a] = make([]float64, n)
+		for b := range quality[a] {
+			quality[a][b] = -1
+		}
+	}
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			pa, pb := players[window[a]], players[window[b]]
+			if CanMatch(pa, pb, rules) {
+				q := MatchQuality(pa, pb, rules, now)
+				quality[a][b], quality[b][a] = q, q
+			}
+		}
+	}
+
+	full := 1<<n - 1
+	best := make([]float64, full+1)
+	choice := make([]int, full+1) // partner of the lowest undecided entry, -1 to leave it unpaired
+	done := make([]bool, full+1)
+
+	var solve func(mask int) float64
+	solve = func(mask int) float64 {
+		if mask == full {
+			return 0
+		}
+		if done[mask] {
+			return best[mask]
+		}
+		a := 0
+		for mask&(1<<a) != 0 {
+			a++
+		}
+		best[mask], choice[mask] = solve(mask|1<<a), -1
+		for b := a + 1; b < n; b++ {
+			if mask&(1<<b) != 0 || quality[a][b] < 0 {
+				continue
+			}
+			if v := quality[a][b] + solve(mask|1<<a|1<<b); v > best[mask] {
+				best[mask], choice[mask] = v, b
+			}
+		}
+		done[mask] = true
+		return best[mask]
+	}
+	solve(0)
+
+	var out []Proposal
+	for mask := 0; mask != full; {
+		a := 0
+		for mask&(1<<a) != 0 {
+			a++
+		}
+		b := choice[mask]
+		if b < 0 {
+			mask |= 1 << a
+			continue
+		}
+		out = append(out, Proposal{A: window[a], B: window[b], Quality: quality[a][b]})
+		mask |= 1<<a | 1<<b
+	}
+	return out
+}
+
+// FormGroups fills matches of size players, for modes that don't pair two players.
+// Players are sorted by MMR, so the first run of size neighbours who can all play
+// each other becomes a match and the search carries on after it.
+func FormGroups(players []models.Player, size int, rules config.Matchmaking) [][]int {
+	var groups [][]int
+	for start := 0; start+size <= len(players); {
+		if !allCompatible(players[start:start+size], rules) {
+			start++
+			continue
+		}
+		group := make([]int, size)
+		for i := range group {
+			group[i] = start + i
+		}
+		groups = append(groups, group)
+		start += size
+	}
+	return groups
+}
+
+func allCompatible(players []models.Player, rules config.Matchmaking) bool {
+	for a := range players {
+		for b := a + 1; b < len(players); b++ {
+			if !CanMatch(players[a], players[b], rules) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package matchmaking
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current results")
+
+// TestMatchersGolden replays the recorded queue snapshots in testdata through every
+// matching strategy and compares what each made of them to testdata/golden. A
+// strategy change shows up as a golden diff to review; rerun with -update when the
+// change is intended. Record new snapshots with mmcompare -record.
+func TestMatchersGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no snapshots in testdata")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var snap Snapshot
+			if err := json.Unmarshal(data, &snap); err != nil {
+				t.Fatalf("reading snapshot: %v", err)
+			}
+
+			var evals []Evaluation
+			for _, m := range Matchers(snap.Rules) {
+				ev := Evaluate(snap, m)
+				if ev.Violations > 0 {
+					t.Errorf("%s made %d proposals breaking the Matcher contract", ev.Matcher, ev.Violations)
+				}
+				// the same snapshot has to give the same answer every time
+				if again := Evaluate(snap, m); !equalJSON(t, ev, again) {
+					t.Errorf("%s is not deterministic", ev.Matcher)
+				}
+				evals = append(evals, ev)
+			}
+
+			got, err := json.MarshalIndent(evals, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "golden", name+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(want, got) {
+				t.Errorf("results differ from %s, rerun with -update if the change is intended\ngot:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func equalJSON(t *testing.T, a, b any) bool {
+	t.Helper()
+	x, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(x, y)
+}
@@ -1,34 +1,74 @@
 package matchmaking
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/events"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
 	"github.com/redis/go-redis/v9"
 )
 
-func JoinQueue(db databases.Database) http.HandlerFunc {
+func JoinQueue(db databases.Database, stream *events.Stream, rules *RuleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var player models.Player
-		fmt.Println("match request received")
+		logger.FromContext(r.Context()).Debug("match request received")
 		err := json.NewDecoder(r.Body).Decode(&player)
 		if err != nil {
 			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
 			return
 		}
 
-		fmt.Println("player decoded")
+		ctx := logger.With(r.Context(), slog.String("player_id", player.ID))
+		log := logger.FromContext(ctx)
+		log.Debug("player decoded")
 
-		ctx := r.Context()
+		active := rules.Current().Rules
+		if !active.HasRegion(player.Region) {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("unknown region %q", player.Region)))
+			return
+		}
+		mode, ok := active.Mode(player.Mode)
+		if !ok {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("unknown mode %q", player.Mode)))
+			return
+		}
+		player.Mode = mode.Name
+
+		// the mode is matched on its own rating pool, only that rating is queued
+		if mmr, ok := player.Ratings[mode.RatingPool()]; ok {
+			player.MMR = mmr
+		}
+		player.Ratings = nil
+		tier := active.ForMode(mode).Tiers.For(player.MMR)
+
+		// a measured latency to the home region wins over the single ping number
+		if ping, ok := player.Latency[player.Region]; ok {
+			player.Ping = ping
+		}
+
+		// Wait time is measured from when the server saw the player, not the client clock
+		player.JoinedAt = time.Now().Unix()
 
 		// Persist player to database first
-		if err := db.CreatePlayer(ctx, player); err != nil {
-			fmt.Printf("Error creating player in DB: %v\n", err)
+		err = db.CreatePlayer(ctx, player, tier)
+		if errors.Is(err, databases.ErrPlayerAlreadyQueued) {
+			response.WriteJson(w, http.StatusConflict, response.GeneralError(fmt.Errorf("player %q is already queued", player.ID)))
+			return
+		}
+		if err != nil {
+			log.Error("Error creating player in DB", slog.String("error", err.Error()))
 			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(fmt.Errorf("failed to persist player: %w", err)))
 			return
 		}
@@ -39,8 +79,6 @@ func JoinQueue(db databases.Database) http.HandlerFunc {
 			return
 		}
 
-		fmt.Println("redis client initialized")
-
 		// Marshal player to JSON
 		pBytes, err := json.Marshal(player)
 		if err != nil {
@@ -49,35 +87,98 @@ func JoinQueue(db databases.Database) http.HandlerFunc {
 		}
 
 		// Enqueue player
-		tier := GetTier(player.MMR)
-		queueName := GetQueueName(player.Region, tier) // e.g. queue:US:newbie
+		queueName := GetQueueName(mode.Name, player.Region, tier) // e.g. queue:ranked_1v1:US:newbie
 
-		fmt.Printf("enqueueing player to %s\n", queueName)
+		log = log.With(slog.String("queue", queueName))
+		log.Debug("enqueueing player")
 
-		err = redisClient.ZAdd(ctx, queueName, redis.Z{
-			Score:  float64(player.MMR),
-			Member: pBytes,
-		}).Err()
-		if err != nil {
+		if err := enqueue(ctx, redisClient, queueName, player, pBytes); err != nil {
 			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
 			return
 		}
-		fmt.Println("player enqueued to ZSET, publishing new player event")
+		log.Debug("player enqueued to ZSET, publishing queue_joined event")
+
+		// Wake up a matchmaker. The player is already queued, so if this fails the next sweep still finds them.
+		err = stream.Publish(ctx, events.Event{Type: events.QueueJoined, PlayerID: player.ID, Mode: mode.Name, Region: player.Region, Tier: tier})
+		if err != nil {
+			log.Warn("Failed to publish queue_joined event", slog.String("error", err.Error()))
+		}
+		log.Info("player joined queue")
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: "waiting for match",
+			Data:   nil,
+		})
+	}
+}
+
+// LeaveQueue takes a waiting player off the queue
+func LeaveQueue(db databases.Database, stream *events.Stream, rules *RuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var player models.Player
+		if err := json.NewDecoder(r.Body).Decode(&player); err != nil {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(err))
+			return
+		}
+
+		ctx := logger.With(r.Context(), slog.String("player_id", player.ID))
+		log := logger.FromContext(ctx)
+
+		redisClient := utils.GetClient()
+		if redisClient == nil {
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(fmt.Errorf("redis client is nil")))
+			return
+		}
 
-		// Publish event
-		err = redisClient.Publish(ctx, "matchmaking_channel", "new_player").Err() // something changed. Wake up Suscriber
+		entry, err := removeQueuedPlayer(ctx, redisClient, rules.Current().Rules, player)
 		if err != nil {
 			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
 			return
 		}
-		fmt.Println("new player event published")
+		if entry == nil {
+			response.WriteJson(w, http.StatusNotFound, response.GeneralError(fmt.Errorf("player %q is not queued", player.ID)))
+			return
+		}
+
+		if err := db.MarkPlayerLeft(ctx, player.ID); errors.Is(err, sql.ErrNoRows) {
+			// matched while leaving, the match stands
+			response.WriteJson(w, http.StatusConflict, response.GeneralError(fmt.Errorf("player %q is already matched", player.ID)))
+			return
+		} else if err != nil {
+			log.Error("Error marking player as left", slog.String("error", err.Error()))
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
+			return
+		}
+
+		err = stream.Publish(ctx, events.Event{Type: events.QueueLeft, PlayerID: player.ID, Mode: entry.Mode, Region: entry.Region, Tier: entry.Tier})
+		if err != nil {
+			log.Warn("Failed to publish queue_left event", slog.String("error", err.Error()))
+		}
+
+		log.Info("player left queue", slog.String("queue", entry.Queue))
 		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
-			Status: "waiting for match",
+			Status: response.StatusOK,
 			Data:   nil,
 		})
 	}
 }
 
+// removeQueuedPlayer takes the player off their queue and returns the entry removed,
+// nil if the player wasn't queued. The request's mode and region narrow the search.
+func removeQueuedPlayer(ctx context.Context, redisClient *redis.Client, rules config.Matchmaking, player models.Player) (*queuedPlayer, error) {
+	entry, err := findQueuedPlayer(ctx, redisClient, rules, player.ID, player.Mode, player.Region)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	removed, err := dequeue(ctx, redisClient, entry.Queue, entry.Player.ID, entry.Member)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, nil // the matchmaker got there first
+	}
+	return entry, nil
+}
+
 func GetMatchStatus(db databases.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var player models.Player
@@ -0,0 +1,140 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/events"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/metrics"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// OutboxRelay applies the side effects CreateMatch committed to the outbox table:
+// taking the players off their Redis queue and announcing the match. Every effect
+// is safe to repeat, so a message is retried until it succeeds and the queue ends
+// up agreeing with the database whatever crashed in between.
+type OutboxRelay struct {
+	DB     databases.Database
+	Events *events.Stream
+	Redis  *redis.Client
+
+	// WaitSamples is how many recent times to match are kept per queue for wait estimates
+	WaitSamples int64
+
+	mu sync.Mutex // one Flush at a time
+}
+
+// Run flushes the outbox every interval until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Flush(ctx)
+		}
+	}
+}
+
+// Flush applies every pending outbox message. Failed messages stay pending for the next flush.
+func (r *OutboxRelay) Flush(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := logger.FromContext(ctx)
+
+	msgs, err := r.DB.PendingOutbox(ctx, 100)
+	if err != nil {
+		log.Error("Failed to read outbox", slog.String("error", err.Error()))
+		return
+	}
+
+	for _, msg := range msgs {
+		if err := r.apply(ctx, msg); err != nil {
+			log.Warn("Failed to apply outbox message",
+				slog.Int64("outbox_id", msg.ID),
+				slog.String("kind", msg.Kind),
+				slog.Int("attempts", msg.Attempts+1),
+				slog.String("error", err.Error()),
+			)
+			if err := r.DB.MarkOutboxFailed(ctx, msg.ID, err.Error()); err != nil {
+				log.Error("Failed to record outbox failure", slog.Int64("outbox_id", msg.ID), slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if err := r.DB.MarkOutboxDone(ctx, msg.ID); err != nil {
+			// applied again on the next flush, which is harmless
+			log.Error("Failed to mark outbox message done", slog.Int64("outbox_id", msg.ID), slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (r *OutboxRelay) apply(ctx context.Context, msg models.OutboxMessage) error {
+	switch msg.Kind {
+	case models.OutboxMatchCreated:
+		var effects models.MatchCreatedEffects
+		if err := json.Unmarshal(msg.Payload, &effects); err != nil {
+			return err
+		}
+		return r.matchCreated(ctx, msg.ID, effects)
+	default:
+		return fmt.Errorf("unknown outbox kind %q", msg.Kind)
+	}
+}
+
+func (r *OutboxRelay) matchCreated(ctx context.Context, outboxID int64, effects models.MatchCreatedEffects) error {
+	for i, member := range effects.Members {
+		queueName, tier := effects.Queue, effects.Tier
+		if i < len(effects.Queues) {
+			queueName = effects.Queues[i]
+		}
+		if i < len(effects.Tiers) {
+			tier = effects.Tiers[i]
+		}
+
+		var p models.Player
+		if err := json.Unmarshal([]byte(member), &p); err != nil {
+			// can't be indexed either, just take it off the queue
+			if err := r.Redis.ZRem(ctx, queueName, member).Err(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		removed, err := dequeue(ctx, r.Redis, queueName, p.ID, member)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			continue // already off the queue from an earlier attempt
+		}
+
+		wait := time.Since(time.Unix(p.JoinedAt, 0))
+		metrics.TimeToMatch.WithLabelValues(effects.Match.Mode, p.Region, tier).Observe(wait.Seconds())
+		if err := recordWait(ctx, r.Redis, effects.Match.Mode, p.Region, tier, wait, r.WaitSamples); err != nil {
+			logger.FromContext(ctx).Warn("Failed to record wait", slog.String("error", err.Error()))
+		}
+	}
+
+	match := effects.Match
+	return r.Events.Publish(ctx, events.Event{
+		Type:     events.MatchCreated,
+		MatchID:  match.ID,
+		Players:  match.Players,
+		Mode:     match.Mode,
+		Region:   match.Region,
+		Tier:     effects.Tier,
+		NodeID:   match.NodeID,
+		OutboxID: outboxID,
+	})
+}
@@ -0,0 +1,177 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// The players table is the durable copy of the queue: a player stays 'waiting'
+// there until CreateMatch commits. Redis only holds the working set.
+
+/*
+Redis layout:
+
+	queue:<mode>:<region>:<tier>  ZSET of queued players as JSON, scored by MMR
+	queued:<player id>            HASH queue, member: where the player sits, so they can be
+	                              found without scanning every queue. Only trusted while the
+	                              member is still in that queue.
+*/
+
+func queuedKey(playerID string) string {
+	return fmt.Sprintf("queued:%s", playerID)
+}
+
+// dequeueScript takes a member off its queue, and drops the player's index entry
+// if it still points at that member rather than a later join. Returns 1 if the
+// member was queued.
+// KEYS: queue, index. ARGV: member
+var dequeueScript = redis.NewScript(`
+local removed = redis.call('ZREM', KEYS[1], ARGV[1])
+if redis.call('HGET', KEYS[2], 'member') == ARGV[1] then
+	redis.call('DEL', KEYS[2])
+end
+return removed
+`)
+
+// moveScript moves a member to another queue and the player's index along with it.
+// Returns 1 if the member was still queued to move.
+// KEYS: from, to, index. ARGV: member, score
+var moveScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+if redis.call('HGET', KEYS[3], 'member') == ARGV[1] then
+	redis.call('HSET', KEYS[3], 'queue', KEYS[2])
+end
+return 1
+`)
+
+// enqueue adds a player's entry to a queue and indexes where it went
+func enqueue(ctx context.Context, redisClient *redis.Client, queueName string, p models.Player, member []byte) error {
+	pipe := redisClient.TxPipeline()
+	pipe.ZAdd(ctx, queueName, redis.Z{Score: float64(p.MMR), Member: member})
+	pipe.HSet(ctx, queuedKey(p.ID), "queue", queueName, "member", member)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// dequeue takes a player's entry off a queue, reporting whether it was there
+func dequeue(ctx context.Context, redisClient *redis.Client, queueName, playerID, member string) (bool, error) {
+	removed, err := dequeueScript.Run(ctx, redisClient, []string{queueName, queuedKey(playerID)}, member).Int()
+	return removed == 1, err
+}
+
+// PersistQueues makes sure everyone still sitting in a Redis queue is recorded as
+// waiting, so RestoreQueues can put them back after a restart.
+// Run it once the matchmaker has stopped.
+func PersistQueues(ctx context.Context, db databases.Database, rules config.Matchmaking) error {
+	redisClient := utils.GetClient()
+	log := logger.FromContext(ctx)
+
+	var ids []string
+	for _, mode := range rules.ModeList() {
+		modeRules := rules.ForMode(mode)
+		for _, region := range modeRules.Regions {
+			for _, tier := range modeRules.Tiers.Names() {
+				vals, err := redisClient.ZRange(ctx, GetQueueName(mode.Name, region, tier), 0, -1).Result()
+				if err != nil {
+					return err
+				}
+				for _, v := range vals {
+					var p models.Player
+					if err := json.Unmarshal([]byte(v), &p); err == nil {
+						ids = append(ids, p.ID)
+					}
+				}
+			}
+		}
+	}
+
+	if err := db.MarkPlayersWaiting(ctx, ids); err != nil {
+		return err
+	}
+	log.Info("Queued players persisted", slog.Int("players", len(ids)))
+	return nil
+}
+
+// RestoreQueues re-enqueues every player the database still has as waiting.
+// Re-adding a player already in Redis is a no-op since the member is identical.
+// The matchmaker's startup pass picks them up.
+func RestoreQueues(ctx context.Context, db databases.Database, rules config.Matchmaking) error {
+	redisClient := utils.GetClient()
+	log := logger.FromContext(ctx)
+
+	players, err := db.GetWaitingPlayers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range players {
+		// players from before modes existed have none and go back to the default
+		mode, ok := rules.Mode(p.Mode)
+		if !ok {
+			log.Warn("Queued player's mode no longer exists", slog.String("player_id", p.ID), slog.String("mode", p.Mode))
+			continue
+		}
+		p.Mode = mode.Name
+
+		pBytes, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if err := enqueue(ctx, redisClient, GetQueueName(mode.Name, p.Region, rules.ForMode(mode).Tiers.For(p.MMR)), p, pBytes); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Queued players restored", slog.Int("players", len(players)))
+	return nil
+}
+
+// queuedPlayer is a player's entry in a Redis queue
+type queuedPlayer struct {
+	Player models.Player
+	Mode   string
+	Rules  config.Matchmaking // the mode's
+	Region string
+	Tier   string
+	Queue  string
+	Member string // raw entry as stored
+}
+
+// findQueuedPlayer looks the player up in the queue index. A mode or region, when
+// given, must match where they are queued. Returns nil if the player isn't queued.
+func findQueuedPlayer(ctx context.Context, redisClient *redis.Client, rules config.Matchmaking, playerID, mode, region string) (*queuedPlayer, error) {
+	index, err := redisClient.HGetAll(ctx, queuedKey(playerID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	queueName, member := index["queue"], index["member"]
+	if queueName == "" || member == "" {
+		return nil, nil
+	}
+
+	m, r, tier, ok := parseQueueName(queueName)
+	if !ok || (mode != "" && m != mode) || (region != "" && r != region) {
+		return nil, nil
+	}
+	modeRules, ok := rules.Mode(m)
+	if !ok {
+		return nil, nil // mode removed, the player is stranded until it returns
+	}
+	var p models.Player
+	if err := json.Unmarshal([]byte(member), &p); err != nil {
+		return nil, err
+	}
+	return &queuedPlayer{Player: p, Mode: m, Rules: rules.ForMode(modeRules), Region: r, Tier: tier, Queue: queueName, Member: member}, nil
+}
@@ -0,0 +1,341 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+Redis layout:
+
+	matchmaking:rules  STRING the RuleSet every replica runs with, as JSON
+*/
+const rulesKey = "matchmaking:rules"
+
+// applyAttempts bounds how often Apply retries when another replica changed the rules first
+const applyAttempts = 5
+
+// RuleSet is one validated version of the matchmaking rules
+type RuleSet struct {
+	Version  int64              `json:"version"` // unix millis of when the rules were applied, recorded on matches
+	Source   string             `json:"source"`  // what applied them: startup, file or admin
+	LoadedAt time.Time          `json:"loaded_at"`
+	Rules    config.Matchmaking `json:"rules"`
+}
+
+// RuleStore holds the rules the matchmaker is running with. Readers take a
+// snapshot with Current and keep it for a whole pass, so a swap only ever
+// becomes visible between processQueue passes.
+//
+// With Redis the rules are shared: Apply writes them to Redis, and Sync picks up
+// what any replica applied. Rules already in Redis win over the ones a replica
+// starts with, so restarting with an old config file doesn't roll them back.
+type RuleStore struct {
+	current atomic.Pointer[RuleSet]
+	rdb     *redis.Client // nil keeps the rules to this process
+	mu      sync.Mutex    // serialises Apply
+}
+
+// NewRuleStore starts from initial until Redis says otherwise, rdb may be nil
+func NewRuleStore(initial config.Matchmaking, rdb *redis.Client) *RuleStore {
+	s := &RuleStore{rdb: rdb}
+	s.current.Store(&RuleSet{
+		Version:  time.Now().UnixMilli(),
+		Source:   "startup",
+		LoadedAt: time.Now(),
+		Rules:    initial,
+	})
+	return s
+}
+
+func (s *RuleStore) Current() *RuleSet {
+	return s.current.Load()
+}
+
+// Apply validates next and swaps it in, for every replica when the rules are
+// shared. Unchanged rules are not a new version.
+func (s *RuleStore) Apply(ctx context.Context, next config.Matchmaking, source string) (*RuleSet, error) {
+	if err := next.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid matchmaking rules: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rdb == nil {
+		prev := s.current.Load()
+		set, changes := nextRuleSet(prev, next, source)
+		if len(changes) > 0 {
+			s.swap(ctx, prev, set, changes)
+		}
+		return set, nil
+	}
+
+	// compare and set against Redis, so two replicas applying at once both end up
+	// on whichever version was written last
+	for attempt := 0; attempt < applyAttempts; attempt++ {
+		var set *RuleSet
+		var changes []string
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			prev, err := s.shared(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if prev == nil {
+				prev = s.current.Load()
+			}
+			set, changes = nextRuleSet(prev, next, source)
+			if len(changes) == 0 {
+				return nil
+			}
+			data, err := json.Marshal(set)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, rulesKey, data, 0)
+				return nil
+			})
+			return err
+		}, rulesKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue // another replica applied rules in between, diff against those
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if prev := s.current.Load(); prev.Version != set.Version {
+			if len(changes) == 0 {
+				changes = diffRules(prev.Rules, set.Rules)
+			}
+			s.swap(ctx, prev, set, changes)
+		}
+		return set, nil
+	}
+	return nil, errors.New("matchmaking rules kept changing, try again")
+}
+
+// nextRuleSet is next as a new version after prev, or prev itself when nothing changed
+func nextRuleSet(prev *RuleSet, next config.Matchmaking, source string) (*RuleSet, []string) {
+	changes := diffRules(prev.Rules, next)
+	if len(changes) == 0 {
+		return prev, nil
+	}
+	version := time.Now().UnixMilli()
+	if version <= prev.Version {
+		version = prev.Version + 1
+	}
+	return &RuleSet{Version: version, Source: source, LoadedAt: time.Now(), Rules: next}, changes
+}
+
+// shared reads the rules in Redis, nil when no replica has written any yet
+func (s *RuleStore) shared(ctx context.Context, cmd redis.Cmdable) (*RuleSet, error) {
+	data, err := cmd.Get(ctx, rulesKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set RuleSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// swap makes set the rules this replica runs with. Must hold s.mu.
+func (s *RuleStore) swap(ctx context.Context, prev, set *RuleSet, changes []string) {
+	s.current.Store(set)
+
+	logger.FromContext(ctx).Info("Matchmaking rules updated",
+		slog.Int64("version", set.Version),
+		slog.Int64("previous_version", prev.Version),
+		slog.String("source", set.Source),
+		slog.Any("changes", changes),
+	)
+}
+
+// Sync keeps this replica on the shared rules until ctx is cancelled, checking
+// Redis every interval. The first check publishes this replica's rules if no
+// replica has yet. Does nothing without Redis.
+func (s *RuleStore) Sync(ctx context.Context, interval time.Duration) {
+	if s.rdb == nil {
+		return
+	}
+	ctx = logger.With(ctx, slog.String("component", "rules_sync"))
+	log := logger.FromContext(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.pull(ctx); err != nil {
+			log.Error("Cannot sync matchmaking rules", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pull adopts the shared rules when they are a different version from ours
+func (s *RuleStore) pull(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.current.Load()
+	set, err := s.shared(ctx, s.rdb)
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		data, err := json.Marshal(prev)
+		if err != nil {
+			return err
+		}
+		return s.rdb.SetNX(ctx, rulesKey, data, 0).Err() // the next pull adopts whoever won
+	}
+	if set.Version == prev.Version {
+		return nil
+	}
+	if err := set.Rules.Validate(); err != nil {
+		return fmt.Errorf("shared matchmaking rules version %d are invalid: %w", set.Version, err)
+	}
+	s.swap(ctx, prev, set, diffRules(prev.Rules, set.Rules))
+	return nil
+}
+
+// WatchFile re-reads the matchmaking section of the config file whenever it
+// changes on disk, until ctx is cancelled. Invalid edits are logged and skipped.
+func (s *RuleStore) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	ctx = logger.With(ctx, slog.String("component", "rules_watcher"), slog.String("path", path))
+	log := logger.FromContext(ctx)
+
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Warn("Cannot stat config file", slog.String("error", err.Error()))
+			continue
+		}
+		if !info.ModTime().After(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+
+		rules, err := config.LoadMatchmaking(path)
+		if err != nil {
+			log.Error("Cannot read matchmaking rules", slog.String("error", err.Error()))
+			continue
+		}
+		if _, err := s.Apply(ctx, rules, "file"); err != nil {
+			log.Error("Rejected matchmaking rules", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// diffRules describes each field that differs between two rule sets
+func diffRules(prev, next config.Matchmaking) []string {
+	var changes []string
+
+	if added, removed := diffStrings(prev.Regions, next.Regions); len(added) > 0 || len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("regions: added %v removed %v", added, removed))
+	}
+	if !reflect.DeepEqual(prev.Tiers, next.Tiers) {
+		changes = append(changes, fmt.Sprintf("tiers: %s -> %s", formatTiers(prev.Tiers), formatTiers(next.Tiers)))
+	}
+	if prev.MaxMMRGap != next.MaxMMRGap {
+		changes = append(changes, fmt.Sprintf("max_mmr_gap: %d -> %d", prev.MaxMMRGap, next.MaxMMRGap))
+	}
+	if prev.FallbackAfter != next.FallbackAfter {
+		changes = append(changes, fmt.Sprintf("fallback_after: %s -> %s", prev.FallbackAfter, next.FallbackAfter))
+	}
+	if prev.FallbackMaxPing != next.FallbackMaxPing {
+		changes = append(changes, fmt.Sprintf("fallback_max_ping: %d -> %d", prev.FallbackMaxPing, next.FallbackMaxPing))
+	}
+	if prev.MaxPing != next.MaxPing {
+		changes = append(changes, fmt.Sprintf("max_ping: %d -> %d", prev.MaxPing, next.MaxPing))
+	}
+	if prev.MaxPingDiff != next.MaxPingDiff {
+		changes = append(changes, fmt.Sprintf("max_ping_diff: %d -> %d", prev.MaxPingDiff, next.MaxPingDiff))
+	}
+	if prev.Quality != next.Quality {
+		changes = append(changes, fmt.Sprintf("quality: %+v -> %+v", prev.Quality, next.Quality))
+	}
+	if prev.Matcher != next.Matcher {
+		changes = append(changes, fmt.Sprintf("matcher: %s -> %s", prev.Matcher, next.Matcher))
+	}
+	if prev.MatcherWindow != next.MatcherWindow {
+		changes = append(changes, fmt.Sprintf("matcher_window: %d -> %d", prev.MatcherWindow, next.MatcherWindow))
+	}
+	if !reflect.DeepEqual(prev.Matchers, next.Matchers) {
+		changes = append(changes, fmt.Sprintf("matchers: %+v -> %+v", prev.Matchers, next.Matchers))
+	}
+	if prev.DefaultMode != next.DefaultMode {
+		changes = append(changes, fmt.Sprintf("default_mode: %s -> %s", prev.DefaultMode, next.DefaultMode))
+	}
+	if !reflect.DeepEqual(prev.Modes, next.Modes) {
+		changes = append(changes, fmt.Sprintf("modes: %+v -> %+v", prev.Modes, next.Modes))
+	}
+	return changes
+}
+
+func diffStrings(prev, next []string) (added, removed []string) {
+	seen := make(map[string]bool, len(prev))
+	for _, v := range prev {
+		seen[v] = true
+	}
+	for _, v := range next {
+		if !seen[v] {
+			added = append(added, v)
+		}
+		delete(seen, v)
+	}
+	for _, v := range prev {
+		if seen[v] {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+func formatTiers(tiers config.Tiers) string {
+	s := ""
+	for i, t := range tiers {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%s:%d", t.Name, t.MinMMR)
+	}
+	return s
+}
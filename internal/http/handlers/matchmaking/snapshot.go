@@ -0,0 +1,117 @@
+package matchmaking
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Snapshot is a recorded pool of queued players. Together with its rules and
+// clock it is everything a Matcher sees, so replaying it is deterministic.
+type Snapshot struct {
+	Queue   string             `json:"queue"`
+	Mode    string             `json:"mode,omitempty"`
+	Region  string             `json:"region"`
+	Tier    string             `json:"tier"`
+	Now     int64              `json:"now"`     // unix seconds
+	Rules   config.Matchmaking `json:"rules"`   // the mode's
+	Players []models.Player    `json:"players"` // sorted by MMR, as seen from the pool
+}
+
+// Evaluation summarises what a matcher made of a snapshot
+type Evaluation struct {
+	Matcher      string      `json:"matcher"`
+	Pairs        [][2]string `json:"pairs"`
+	Matched      int         `json:"matched"`
+	Unmatched    int         `json:"unmatched"`
+	TotalQuality float64     `json:"total_quality"`
+	MeanQuality  float64     `json:"mean_quality"`
+	MinQuality   float64     `json:"min_quality"`
+	MeanWait     float64     `json:"mean_wait_seconds"`    // of matched players
+	LongestLeft  float64     `json:"longest_left_seconds"` // longest wait among unmatched players
+	Violations   int         `json:"violations"`           // proposals breaking the Matcher contract
+}
+
+// RecordSnapshots captures every non-empty pool of the two player modes, the ones
+// matchers pair, as the matcher would read it right now
+func RecordSnapshots(ctx context.Context, redisClient *redis.Client, rules config.Matchmaking) ([]Snapshot, error) {
+	now := time.Now()
+
+	var out []Snapshot
+	for _, mode := range rules.ModeList() {
+		if mode.Size() != 2 {
+			continue
+		}
+		modeRules := rules.ForMode(mode)
+		for _, region := range modeRules.Regions {
+			for _, tier := range modeRules.Tiers.Names() {
+				candidates, err := readPool(ctx, redisClient, modeRules, mode.Name, region, tier, now)
+				if err != nil {
+					return nil, err
+				}
+				if len(candidates) == 0 {
+					continue
+				}
+
+				snap := Snapshot{Queue: GetQueueName(mode.Name, region, tier), Mode: mode.Name, Region: region, Tier: tier, Now: now.Unix(), Rules: modeRules}
+				for _, c := range candidates {
+					snap.Players = append(snap.Players, c.player)
+				}
+				out = append(out, snap)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Evaluate runs a matcher over a snapshot and checks its proposals against the contract
+func Evaluate(snap Snapshot, matcher Matcher) Evaluation {
+	players := append([]models.Player(nil), snap.Players...)
+	sort.SliceStable(players, func(i, j int) bool { return players[i].MMR < players[j].MMR })
+	now := time.Unix(snap.Now, 0)
+
+	ev := Evaluation{Matcher: matcher.Name(), Pairs: [][2]string{}}
+	used := make([]bool, len(players))
+	var waited float64
+	for _, p := range matcher.Match(players, snap.Rules, now) {
+		if p.A < 0 || p.B < 0 || p.A >= len(players) || p.B >= len(players) || p.A == p.B ||
+			used[p.A] || used[p.B] || !CanMatch(players[p.A], players[p.B], snap.Rules) {
+			ev.Violations++
+			continue
+		}
+		used[p.A], used[p.B] = true, true
+
+		ev.Pairs = append(ev.Pairs, [2]string{players[p.A].ID, players[p.B].ID})
+		ev.TotalQuality += p.Quality
+		if len(ev.Pairs) == 1 || p.Quality < ev.MinQuality {
+			ev.MinQuality = p.Quality
+		}
+		waited += now.Sub(time.Unix(players[p.A].JoinedAt, 0)).Seconds() + now.Sub(time.Unix(players[p.B].JoinedAt, 0)).Seconds()
+	}
+
+	ev.Matched = 2 * len(ev.Pairs)
+	ev.Unmatched = len(players) - ev.Matched
+	if len(ev.Pairs) > 0 {
+		ev.MeanQuality = ev.TotalQuality / float64(len(ev.Pairs))
+		ev.MeanWait = waited / float64(ev.Matched)
+	}
+	// keep golden files stable across platforms
+	ev.TotalQuality, ev.MeanQuality, ev.MinQuality = round6(ev.TotalQuality), round6(ev.MeanQuality), round6(ev.MinQuality)
+	ev.MeanWait = round6(ev.MeanWait)
+
+	for i, p := range players {
+		if !used[i] {
+			ev.LongestLeft = math.Max(ev.LongestLeft, now.Sub(time.Unix(p.JoinedAt, 0)).Seconds())
+		}
+	}
+	return ev
+}
+
+func round6(f float64) float64 {
+	return math.Round(f*1e6) / 1e6
+}
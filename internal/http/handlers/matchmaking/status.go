@@ -0,0 +1,264 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/socket"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// QueueStatus tells a waiting player where they stand
+type QueueStatus struct {
+	PlayerID      string  `json:"player_id"`
+	Queue         string  `json:"queue"`
+	Mode          string  `json:"mode"`
+	Region        string  `json:"region"`
+	Tier          string  `json:"tier"`
+	Position      int64   `json:"position"` // 1 is the lowest MMR in the queue
+	QueueSize     int64   `json:"queue_size"`
+	Nearby        int     `json:"nearby"` // players the matchmaker could pair them with right now
+	WaitedSeconds float64 `json:"waited_seconds"`
+	// EstimatedWaitSeconds is the median time to match in this queue recently,
+	// left out until there are samples
+	EstimatedWaitSeconds *float64 `json:"estimated_wait_seconds,omitempty"`
+	Samples              int      `json:"samples"`
+}
+
+func waitStatsKey(mode, region, tier string) string {
+	return fmt.Sprintf("mmstats:wait:%s:%s:%s", mode, region, tier)
+}
+
+// recordWait adds a time to match to the rolling window for the queue
+func recordWait(ctx context.Context, redisClient *redis.Client, mode, region, tier string, wait time.Duration, keep int64) error {
+	key := waitStatsKey(mode, region, tier)
+	pipe := redisClient.TxPipeline()
+	pipe.LPush(ctx, key, wait.Seconds())
+	pipe.LTrim(ctx, key, 0, keep-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// medianWait returns the median of the rolling window, and how many samples it holds
+func medianWait(ctx context.Context, redisClient *redis.Client, mode, region, tier string) (float64, int, error) {
+	vals, err := redisClient.LRange(ctx, waitStatsKey(mode, region, tier), 0, -1).Result()
+	if err != nil || len(vals) == 0 {
+		return 0, 0, err
+	}
+
+	samples := make([]float64, 0, len(vals))
+	for _, v := range vals {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			samples = append(samples, f)
+		}
+	}
+	if len(samples) == 0 {
+		return 0, 0, nil
+	}
+	sort.Float64s(samples)
+
+	mid := len(samples) / 2
+	if len(samples)%2 == 0 {
+		return (samples[mid-1] + samples[mid]) / 2, len(samples), nil
+	}
+	return samples[mid], len(samples), nil
+}
+
+// queueStatus builds the status of a queued player, or returns nil if they aren't queued
+func queueStatus(ctx context.Context, redisClient *redis.Client, rules config.Matchmaking, playerID, mode, region string) (*QueueStatus, error) {
+	entry, err := findQueuedPlayer(ctx, redisClient, rules, playerID, mode, region)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	p := entry.Player
+	rules = entry.Rules
+
+	rank, err := redisClient.ZRank(ctx, entry.Queue, entry.Member).Result()
+	if err == redis.Nil {
+		return nil, nil // matched since we looked
+	} else if err != nil {
+		return nil, err
+	}
+	size, err := redisClient.ZCard(ctx, entry.Queue).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// count everyone within the MMR gap the matchmaker would accept, which can reach
+	// into the neighbouring tiers
+	gap := rules.MaxMMRGap
+	nearby := 0
+	for _, tier := range rules.Tiers.Span(p.MMR-gap, p.MMR+gap) {
+		window, err := redisClient.ZRangeByScore(ctx, GetQueueName(entry.Mode, entry.Region, tier), &redis.ZRangeBy{
+			Min: strconv.Itoa(p.MMR - gap),
+			Max: strconv.Itoa(p.MMR + gap),
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range window {
+			var other models.Player
+			if err := json.Unmarshal([]byte(v), &other); err != nil || other.ID == p.ID {
+				continue
+			}
+			if CanMatch(p, other, rules) {
+				nearby++
+			}
+		}
+	}
+
+	status := &QueueStatus{
+		PlayerID:      p.ID,
+		Queue:         entry.Queue,
+		Mode:          entry.Mode,
+		Region:        entry.Region,
+		Tier:          entry.Tier,
+		Position:      rank + 1,
+		QueueSize:     size,
+		Nearby:        nearby,
+		WaitedSeconds: math.Round(time.Since(time.Unix(p.JoinedAt, 0)).Seconds()),
+	}
+
+	median, samples, err := medianWait(ctx, redisClient, entry.Mode, entry.Region, entry.Tier)
+	if err != nil {
+		return nil, err
+	}
+	if samples > 0 {
+		estimate := math.Round(median)
+		status.EstimatedWaitSeconds = &estimate
+		status.Samples = samples
+	}
+	return status, nil
+}
+
+// GetQueueStatus reports a queued player's position and expected wait.
+// Query: playerID, and optionally mode and region to skip searching the other queues.
+func GetQueueStatus(rules *RuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playerID := r.URL.Query().Get("playerID")
+		if playerID == "" {
+			response.WriteJson(w, http.StatusBadRequest, response.GeneralError(fmt.Errorf("playerID is required")))
+			return
+		}
+
+		redisClient := utils.GetClient()
+		if redisClient == nil {
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(fmt.Errorf("redis client is nil")))
+			return
+		}
+
+		status, err := queueStatus(r.Context(), redisClient, rules.Current().Rules, playerID, r.URL.Query().Get("mode"), r.URL.Query().Get("region"))
+		if err != nil {
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
+			return
+		}
+		if status == nil {
+			response.WriteJson(w, http.StatusNotFound, response.GeneralError(fmt.Errorf("player %q is not queued", playerID)))
+			return
+		}
+
+		response.WriteJson(w, http.StatusOK, response.SuccessResponse{
+			Status: response.StatusOK,
+			Data:   status,
+		})
+	}
+}
+
+// queueUpdate is a message on the queue socket
+type queueUpdate struct {
+	Type   string        `json:"type"` // queue_status, matched or not_queued
+	Status *QueueStatus  `json:"status,omitempty"`
+	Match  *models.Match `json:"match,omitempty"`
+}
+
+// QueueStatusWs pushes the queue status to a waiting player every interval. Once the
+// player is matched it sends the match, so the client knows where to connect, and closes.
+func QueueStatusWs(db databases.Database, rules *RuleStore, interval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playerID := r.URL.Query().Get("playerID")
+		if playerID == "" {
+			http.Error(w, "playerID is required", http.StatusBadRequest)
+			return
+		}
+		mode, region := r.URL.Query().Get("mode"), r.URL.Query().Get("region")
+
+		log := logger.FromContext(r.Context()).With(slog.String("player_id", playerID))
+		redisClient := utils.GetClient()
+
+		conn, err := socket.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Error("Websocket upgrade failed", slog.String("error", err.Error()))
+			return
+		}
+		defer conn.Close()
+
+		// the request context ends with the handler, watch the connection instead
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if done := pushQueueUpdate(ctx, conn, db, redisClient, rules, playerID, mode, region, log); done {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// pushQueueUpdate sends one update and reports whether the socket is finished
+func pushQueueUpdate(ctx context.Context, conn *websocket.Conn, db databases.Database, redisClient *redis.Client, rules *RuleStore, playerID, mode, region string, log *slog.Logger) bool {
+	status, err := queueStatus(ctx, redisClient, rules.Current().Rules, playerID, mode, region)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn("Failed to build queue status", slog.String("error", err.Error()))
+		}
+		return false
+	}
+	if status != nil {
+		return conn.WriteJSON(queueUpdate{Type: "queue_status", Status: status}) != nil
+	}
+
+	// off the queue: matched, on the way back in after a restart, or gone
+	match, err := db.GetMatch(ctx, playerID)
+	switch {
+	case err == nil && match.Status == "matched":
+		conn.WriteJSON(queueUpdate{Type: "matched", Match: &match})
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "matched"))
+		return true
+	case err == nil && match.Status == "waiting":
+		return false
+	default:
+		conn.WriteJSON(queueUpdate{Type: "not_queued"})
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "not queued"))
+		return true
+	}
+}
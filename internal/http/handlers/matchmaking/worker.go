@@ -3,116 +3,587 @@ package matchmaking
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/allocator"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/config"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/events"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/metrics"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
 	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils"
 	"github.com/redis/go-redis/v9"
 )
 
-// StartMatchmaker listens for new players and attempts to create matches.
-// This should be run as a background goroutine.
-func StartMatchmaker(db databases.Database) {
+var running atomic.Bool
+
+// MatchmakerRunning reports whether the matchmaker goroutine is alive
+func MatchmakerRunning() bool {
+	return running.Load()
+}
+
+// matchmaker holds what a matchmaking pass needs
+type matchmaker struct {
+	db     databases.Database
+	alloc  *allocator.Allocator
+	events *events.Stream
+	outbox *OutboxRelay
+	redis  *redis.Client
+	cfg    *config.Config
+}
+
+// StartMatchmaker runs matchmaking passes until ctx is cancelled. A pass is triggered by
+// queue_joined events read from the event stream, debounced so a burst of joins causes a
+// single pass, and by a periodic sweep so waiting players are re-evaluated even when
+// nobody joins. Events are acknowledged once the pass they triggered has finished, so
+// another replica picks them up if this one dies mid-pass. Each pass runs with the rules
+// current when it starts. It returns once the pass in progress and the outbox relay
+// have stopped, so nothing touches the queues after. This should be run as a
+// background goroutine.
+func StartMatchmaker(ctx context.Context, db databases.Database, alloc *allocator.Allocator, stream *events.Stream, rules *RuleStore, cfg *config.Config) {
 	redisClient := utils.GetClient()
 	if redisClient == nil {
-		log.Println("Redis client is nil, matchmaker cannot start")
+		slog.Error("Redis client is nil, matchmaker cannot start")
 		return
 	}
 
-	ctx := context.Background()
-	pubsub := redisClient.Subscribe(ctx, "matchmaking_channel")
-	defer pubsub.Close()
+	running.Store(true)
+	defer running.Store(false)
+
+	ctx = logger.With(ctx, slog.String("component", "matchmaker"))
+	log := logger.FromContext(ctx)
+
+	consumer := &events.Consumer{
+		Stream:     stream,
+		Group:      cfg.Events.Group,
+		Name:       cfg.GameNode.ID,
+		ClaimAfter: cfg.Events.ClaimAfter,
+		Block:      time.Second,
+	}
+	if err := consumer.EnsureGroup(ctx); err != nil {
+		log.Error("Cannot create event consumer group, relying on sweeps", slog.String("error", err.Error()))
+	}
+	incoming := make(chan []events.Event)
+	go consume(ctx, consumer, incoming)
+
+	// the outbox relay finishes what CreateMatch committed, including anything a crash left behind
+	relay := &OutboxRelay{DB: db, Events: stream, Redis: redisClient, WaitSamples: cfg.QueueStatus.Samples}
+	relay.Flush(ctx)
+	var relayDone sync.WaitGroup
+	relayDone.Add(1)
+	go func() {
+		defer relayDone.Done()
+		relay.Run(ctx, cfg.MatchmakingWorker.OutboxInterval)
+	}()
+	defer relayDone.Wait()
+
+	m := &matchmaker{db: db, alloc: alloc, events: stream, outbox: relay, redis: redisClient, cfg: cfg}
+
+	log.Info("Matchmaker started, waiting for players...")
+
+	active := rules.Current()
+	pass := func(trigger string) {
+		next := rules.Current()
+		if next.Version != active.Version {
+			rebucketQueues(ctx, redisClient, active.Rules, next.Rules)
+			active = next
+		}
+		log.Debug("Matchmaking pass", slog.String("trigger", trigger))
+		m.processQueue(ctx, active)
+	}
+
+	// pick up anyone already queued, e.g. restored after a restart
+	pass("startup")
+
+	sweep := time.NewTicker(cfg.MatchmakingWorker.SweepInterval)
+	defer sweep.Stop()
 
-	ch := pubsub.Channel()
+	// debounce is nil until a wakeup arrives, then fires once for the whole burst
+	var debounce <-chan time.Time
+	// pending holds the IDs of events the next pass handles
+	var pending []string
 
-	fmt.Println("Matchmaker started, waiting for players...")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Matchmaker stopped")
+			return
+		case batch := <-incoming:
+			for _, ev := range batch {
+				log.Debug("Received event", slog.String("type", ev.Type), slog.String("event_id", ev.ID))
+				if ev.Type != events.QueueJoined {
+					// nothing for the matchmaker to do, it's only in the log
+					consumer.Ack(ctx, ev.ID)
+					continue
+				}
+				pending = append(pending, ev.ID)
+				if debounce == nil {
+					debounce = time.After(cfg.MatchmakingWorker.Debounce)
+				}
+			}
+			continue
+		case <-debounce:
+			pass("wakeup")
+		case <-sweep.C:
+			pass("sweep")
+		}
 
-	for msg := range ch {
-		fmt.Printf("Received message: %s\n", msg.Payload)
-		processQueue(ctx, db)
+		// a pass covers any pending wakeup, and the next sweep is due a full interval later
+		if err := consumer.Ack(ctx, pending...); err != nil {
+			log.Warn("Failed to acknowledge events", slog.String("error", err.Error()))
+		}
+		pending = nil
+		debounce = nil
+		sweep.Reset(cfg.MatchmakingWorker.SweepInterval)
 	}
 }
 
-func processQueue(ctx context.Context, db databases.Database) {
-	redisClient := utils.GetClient()
+// consume feeds batches of events to the matchmaker loop until ctx is cancelled
+func consume(ctx context.Context, consumer *events.Consumer, out chan<- []events.Event) {
+	log := logger.FromContext(ctx)
+	for ctx.Err() == nil {
+		batch, err := consumer.Read(ctx, 100)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Failed to read events", slog.String("error", err.Error()))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		select {
+		case out <- batch:
+		case <-ctx.Done():
+		}
+	}
+}
 
-	tiers := []string{"newbie", "specialist", "expert", "candidate_master"}
-	regions := []string{"US", "EU", "ASIA"} // In production, these should be dynamic or config-based
+func (m *matchmaker) processQueue(ctx context.Context, rules *RuleSet) {
+	// a player can show up in more than one pool through the cross-region fallback
+	taken := make(map[string]bool)
 
-	for _, region := range regions {
-		for _, tier := range tiers {
-			queueName := GetQueueName(region, tier)
-			processSpecificQueue(ctx, db, redisClient, queueName)
+	for _, mode := range rules.Rules.ModeList() {
+		modeRules := rules.Rules.ForMode(mode)
+		for _, region := range modeRules.Regions {
+			for _, tier := range modeRules.Tiers.Names() {
+				if ctx.Err() != nil {
+					return // shutting down, the rest waits for the next run
+				}
+				queueName := GetQueueName(mode.Name, region, tier)
+				m.processSpecificQueue(ctx, rules, mode, region, tier, taken)
+
+				if depth, err := m.redis.ZCard(ctx, queueName).Result(); err == nil {
+					metrics.QueueDepth.WithLabelValues(mode.Name, region, tier).Set(float64(depth))
+				}
+			}
 		}
 	}
 }
 
-func processSpecificQueue(ctx context.Context, db databases.Database, redisClient *redis.Client, queueName string) {
-	// Fetch matching players
+// candidate is a queued player considered for a match in one region's pool
+type candidate struct {
+	player models.Player // as seen from the pool: Region and Ping are the pool's
+	home   string        // region the player queued in
+	tier   string        // tier of the queue the player is in
+	queue  string
+	member string // raw entry as stored, so it can be removed exactly
+	guest  bool   // placed here by the cross-region fallback
+}
+
+// QueueSlice is the part of a queue read into a pool, players rated up to MaxMMR
+type QueueSlice struct {
+	Mode, Region, Tier string
+	MaxMMR             int // math.MaxInt for the whole queue
+}
+
+// PoolSources lists the queues of mode read into region and tier's pool, rules being
+// the mode's. Tiers only split the queues, so the pool also takes the bottom of the
+// tiers above, up to MaxMMRGap past the boundary: a player just below it would
+// otherwise never meet one just above it. Other regions' queues are read for guests
+// when the fallback is on. Pools never mix modes.
+func PoolSources(rules config.Matchmaking, mode, region, tier string) (locals, guests []QueueSlice) {
+	slices := []QueueSlice{{Mode: mode, Tier: tier, MaxMMR: math.MaxInt}}
+	for i, t := range rules.Tiers {
+		if t.Name != tier || i+1 == len(rules.Tiers) {
+			continue
+		}
+		upTo := rules.Tiers[i+1].MinMMR - 1 + rules.MaxMMRGap
+		for _, above := range rules.Tiers.Span(rules.Tiers[i+1].MinMMR, upTo) {
+			slices = append(slices, QueueSlice{Mode: mode, Tier: above, MaxMMR: upTo})
+		}
+	}
+
+	for _, source := range rules.Regions {
+		if source != region && rules.FallbackAfter <= 0 {
+			continue
+		}
+		for _, s := range slices {
+			s.Region = source
+			if source == region {
+				locals = append(locals, s)
+			} else {
+				guests = append(guests, s)
+			}
+		}
+	}
+	return locals, guests
+}
+
+// readPool returns everyone mode, region and tier's pool can consider, sorted by MMR
+func readPool(ctx context.Context, redisClient *redis.Client, rules config.Matchmaking, mode, region, tier string, now time.Time) ([]candidate, error) {
+	locals, guests := PoolSources(rules, mode, region, tier)
+
+	var pool []candidate
+	for i, s := range append(locals, guests...) {
+		found, err := readCandidates(ctx, redisClient, rules, s, region, i >= len(locals), now)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", GetQueueName(s.Mode, s.Region, s.Tier), err)
+		}
+		pool = append(pool, found...)
+	}
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].player.MMR < pool[j].player.MMR })
+	return pool, nil
+}
+
+// readCandidates returns the players in a queue slice as seen from region's pool, see PoolView
+func readCandidates(ctx context.Context, redisClient *redis.Client, rules config.Matchmaking, s QueueSlice, region string, guests bool, now time.Time) ([]candidate, error) {
+	queueName := GetQueueName(s.Mode, s.Region, s.Tier)
+	upTo := "+inf"
+	if s.MaxMMR < math.MaxInt {
+		upTo = strconv.Itoa(s.MaxMMR)
+	}
 	// In production, limit this range (e.g. 0-999) and process in batches
-	vals, err := redisClient.ZRange(ctx, queueName, 0, -1).Result()
+	vals, err := redisClient.ZRangeByScore(ctx, queueName, &redis.ZRangeBy{Min: "-inf", Max: upTo}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]candidate, 0, len(vals))
+	for _, v := range vals {
+		var p models.Player
+		if err := json.Unmarshal([]byte(v), &p); err != nil {
+			continue
+		}
+		view, ok := PoolView(p, region, guests, rules, now)
+		if !ok {
+			continue
+		}
+		out = append(out, candidate{player: view, home: p.Region, tier: s.Tier, queue: queueName, member: v, guest: guests})
+	}
+	return out, nil
+}
+
+// PoolView returns a queued player as seen from region's pool: playing in that region,
+// with their ping to it. Guests, players queued in another region, only get a view once
+// they have waited FallbackAfter and their ping to region is at most FallbackMaxPing.
+func PoolView(p models.Player, region string, guest bool, rules config.Matchmaking, now time.Time) (models.Player, bool) {
+	ping, known := p.PingTo(region)
+	if guest {
+		if !known || ping > rules.FallbackMaxPing || now.Sub(time.Unix(p.JoinedAt, 0)) < rules.FallbackAfter {
+			return models.Player{}, false
+		}
+	}
+	p.Region = region
+	if known {
+		p.Ping = ping
+	}
+	return p, true
+}
+
+func (m *matchmaker) processSpecificQueue(ctx context.Context, rules *RuleSet, mode config.Mode, region, tier string, taken map[string]bool) {
+	queueName := GetQueueName(mode.Name, region, tier)
+	ctx = logger.With(ctx, slog.String("queue", queueName))
+	now := time.Now()
+	log := logger.FromContext(ctx)
+	modeRules := rules.Rules.ForMode(mode)
+
+	found, err := readPool(ctx, m.redis, modeRules, mode.Name, region, tier, now)
 	if err != nil {
-		log.Printf("Error reading queue %s: %v\n", queueName, err)
+		log.Error("Error reading queues", slog.String("error", err.Error()))
 		return
 	}
 
-	if len(vals) < 2 {
-		return // Not enough players to match in this queue
+	// players matched earlier in this pass can show up again through the fallback or the tier above
+	players := make([]candidate, 0, len(found))
+	for _, c := range found {
+		if !taken[c.player.ID] {
+			players = append(players, c)
+		}
+	}
+	if len(players) == 0 {
+		return
 	}
 
-	// Parse players
-	players := make([]models.Player, 0, len(vals))
-	for _, v := range vals {
-		var p models.Player
-		if err := json.Unmarshal([]byte(v), &p); err == nil {
-			players = append(players, p)
+	// Everyone in the pool is seen as playing in this region
+	pool := make([]models.Player, len(players))
+	for i, c := range players {
+		pool[i] = c.player
+	}
+
+	// Two player modes go through the queue's matcher, other sizes are filled by FormGroups
+	var groups [][]int
+	var quality []float64 // of each group, for pairs only
+	if mode.Size() == 2 {
+		matcher, err := NewMatcher(modeRules.MatcherFor(region, tier), modeRules)
+		if err != nil {
+			log.Error("Cannot pick a matcher", slog.String("error", err.Error()))
+			return
 		}
+		for _, pr := range matcher.Match(pool, modeRules, now) {
+			groups = append(groups, []int{pr.A, pr.B})
+			quality = append(quality, pr.Quality)
+		}
+	} else {
+		groups = FormGroups(pool, mode.Size(), modeRules)
 	}
 
-	// Match logic - O(N) linear scan as players are sorted by MMR
-	// Since we are in a specific queue, all players are same region and same tier (roughly).
-	// We just need to check if they are "compatible" (Ping, fine-grained MMR gap).
+	for i, group := range groups {
+		members := make([]candidate, len(group))
+		views := make([]models.Player, len(group))
+		ids := make([]string, len(group))
+		free := true
+		for k, idx := range group {
+			members[k], views[k], ids[k] = players[idx], players[idx].player, players[idx].player.ID
+			free = free && !taken[ids[k]]
+		}
+		if !free {
+			continue
+		}
+
+		match := newMatch(mode, views...)
+		match.RulesVersion = rules.Version
+		if err := m.createMatch(ctx, match, tier, members...); err != nil {
+			if errors.Is(err, databases.ErrPlayerNotWaiting) {
+				// Another worker's pool overlaps this one and matched one of them first,
+				// the relay takes them off the queue
+				log.Info("Player already matched", slog.Any("player_ids", ids), slog.String("error", err.Error()))
+				continue
+			}
+			// Nothing was committed, leave the players queued for another group or the next pass
+			log.Warn("Failed to create match", slog.Any("player_ids", ids), slog.String("error", err.Error()))
+			continue
+		}
+		for _, id := range ids {
+			taken[id] = true
+		}
+		if i < len(quality) {
+			metrics.MatchQuality.WithLabelValues(mode.Name, region).Observe(quality[i])
+		}
+	}
 
-	i := 0
-	for i < len(players)-1 {
-		p1 := players[i]
-		p2 := players[i+1]
+	// Backfill anyone who has waited too long with bots, in their home region. Guests
+	// and players from the tier above get theirs in their own queue's pass.
+	if m.cfg.Bots.BackfillAfter <= 0 {
+		return
+	}
+	for _, c := range players {
+		if c.queue != queueName || taken[c.player.ID] || now.Sub(time.Unix(c.player.JoinedAt, 0)) < m.cfg.Bots.BackfillAfter {
+			continue
+		}
 
-		if CanMatch(p1, p2) {
-			// Match found!
-			createMatch(ctx, db, p1, p2)
+		match := newBotMatch(mode, c.player, m.cfg.Bots.Difficulty)
+		match.RulesVersion = rules.Version
+		if err := m.createMatch(ctx, match, tier, c); err != nil {
+			log.Warn("Failed to create bot match",
+				slog.String("player_id", c.player.ID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		taken[c.player.ID] = true
+	}
+}
 
-			// Remove from Redis
-			v1, _ := json.Marshal(p1)
-			v2, _ := json.Marshal(p2)
+// newMatch puts players into a match of mode, split into teams
+func newMatch(mode config.Mode, players ...models.Player) models.Match {
+	ids := make([]string, len(players))
+	for i, p := range players {
+		ids[i] = p.ID
+	}
+	return models.Match{
+		ID:      fmt.Sprintf("%s-%d", strings.Join(ids[:min(2, len(ids))], "-"), time.Now().Unix()),
+		Players: ids,
+		Region:  players[0].Region,
+		Mode:    mode.Name,
+		Teams:   splitTeams(players, mode.Teams),
+	}
+}
 
-			redisClient.ZRem(ctx, queueName, v1)
-			redisClient.ZRem(ctx, queueName, v2)
+// splitTeams deals players out to teams from the highest MMR down, reversing the
+// order every round so the first pick doesn't always go to the same team
+func splitTeams(players []models.Player, teams int) [][]string {
+	order := append([]models.Player(nil), players...)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].MMR > order[j].MMR })
 
-			// Skip next player
-			i += 2
-		} else {
-			// Try next pair
-			i++
+	out := make([][]string, teams)
+	for i, p := range order {
+		team := i % teams
+		if (i/teams)%2 == 1 {
+			team = teams - 1 - team
 		}
+		out[team] = append(out[team], p.ID)
 	}
+	return out
 }
 
-func createMatch(ctx context.Context, db databases.Database, p1, p2 models.Player) {
-	match := models.Match{
-		ID:      fmt.Sprintf("%s-%s-%d", p1.ID, p2.ID, time.Now().Unix()),
-		Players: []string{p1.ID, p2.ID},
-		Region:  p1.Region,
+// newBotMatch fills the rest of a match of mode with server-driven bots
+func newBotMatch(mode config.Mode, p models.Player, difficulty string) models.Match {
+	players := []models.Player{p}
+	var bots []string
+	for i := 1; i < mode.Size(); i++ {
+		botID := fmt.Sprintf("bot-%d-%d", time.Now().UnixNano(), i)
+		players = append(players, models.Player{ID: botID, MMR: p.MMR, Region: p.Region})
+		bots = append(bots, botID)
 	}
+	match := newMatch(mode, players...)
+	match.Bots = bots
+	match.BotDifficulty = difficulty
+	return match
+}
 
-	if err := db.CreateMatch(ctx, match); err != nil {
-		log.Printf("Failed to create match in DB: %v\n", err)
+// createMatch reserves a game node and commits the match. Taking the players off the
+// queue goes through the outbox, so it happens exactly when the match is committed.
+// On error nothing was committed and the players are still queued.
+func (m *matchmaker) createMatch(ctx context.Context, match models.Match, tier string, players ...candidate) error {
+	effects := models.MatchCreatedEffects{Tier: tier, Queue: GetQueueName(match.Mode, match.Region, tier)}
+	for _, c := range players {
+		effects.Members = append(effects.Members, c.member)
+		effects.Queues = append(effects.Queues, c.queue)
+		effects.Tiers = append(effects.Tiers, c.tier)
+		match.Placements = append(match.Placements, models.Placement{
+			PlayerID:    c.player.ID,
+			HomeRegion:  c.home,
+			Ping:        c.player.Ping,
+			CrossRegion: c.home != match.Region,
+		})
+		match.CrossRegion = match.CrossRegion || c.home != match.Region
 	}
 
-	// Here you would typically save the match to a database or Redis
-	// and notify the players (e.g., via another Pub/Sub channel or Websocket).
-	fmt.Printf("Displaying Match Created: %s between %s and %s\n", match.ID, p1.ID, p2.ID)
+	log := logger.FromContext(ctx).With(slog.String("match_id", match.ID), slog.Any("player_ids", match.Players), slog.Int64("rules_version", match.RulesVersion))
+
+	// Pick the game node that will host the match and reserve a slot on it
+	allocation, err := m.alloc.Allocate(ctx, match.Region, match.ID)
+	if err != nil {
+		m.publish(ctx, events.Event{Type: events.MatchFailed, MatchID: match.ID, Players: match.Players, Mode: match.Mode, Region: match.Region, Tier: tier, Reason: err.Error()})
+		return err
+	}
+	match.NodeID = allocation.NodeID
+	match.Endpoint = allocation.Endpoint
+
+	effects.Match = match
+	payload, err := json.Marshal(effects)
+	if err != nil {
+		m.alloc.Release(ctx, match.NodeID, match.ID)
+		return err
+	}
+
+	if err := m.db.CreateMatch(ctx, match, models.OutboxMessage{Kind: models.OutboxMatchCreated, Payload: payload}); err != nil {
+		log.Error("Failed to create match in DB", slog.String("error", err.Error()))
+		m.alloc.Release(ctx, match.NodeID, match.ID)
+		m.publish(ctx, events.Event{Type: events.MatchFailed, MatchID: match.ID, Players: match.Players, Mode: match.Mode, Region: match.Region, Tier: tier, NodeID: match.NodeID, Reason: err.Error()})
+		return err
+	}
+	metrics.MatchesCreated.WithLabelValues(match.Mode, match.Region).Inc()
+	log.Info("Match created", slog.String("node_id", match.NodeID), slog.Bool("cross_region", match.CrossRegion))
+
+	// apply the side effects now rather than waiting for the relay's next tick
+	m.outbox.Flush(ctx)
+	return nil
+}
+
+// publish records an event, losing one only costs the audit log an entry
+func (m *matchmaker) publish(ctx context.Context, ev events.Event) {
+	if err := m.events.Publish(ctx, ev); err != nil {
+		logger.FromContext(ctx).Warn("Failed to publish event", slog.String("type", ev.Type), slog.String("error", err.Error()))
+	}
+}
+
+// rebucketQueues moves queued players whose tier changed under the new rules.
+// Queues of removed modes and regions are left alone, their players are still
+// waiting in the database and come back if the mode or region does.
+func rebucketQueues(ctx context.Context, redisClient *redis.Client, prev, next config.Matchmaking) {
+	log := logger.FromContext(ctx)
+
+	moved := 0
+	for _, mode := range prev.ModeList() {
+		prevRules := prev.ForMode(mode)
+		nextMode, ok := next.Mode(mode.Name)
+		if !ok {
+			if queued := queuedIn(ctx, redisClient, mode.Name, prevRules.Regions, prevRules.Tiers); queued > 0 {
+				log.Warn("Mode removed with players queued", slog.String("mode", mode.Name), slog.Int64("players", queued))
+			}
+			continue
+		}
+		moved += rebucketMode(ctx, redisClient, mode.Name, prevRules, next.ForMode(nextMode))
+	}
+
+	if moved > 0 {
+		log.Info("Players moved to new tiers", slog.Int("players", moved))
+	}
+}
+
+// rebucketMode moves one mode's queued players between tiers, prev and next being
+// the mode's rules. Returns how many moved.
+func rebucketMode(ctx context.Context, redisClient *redis.Client, mode string, prev, next config.Matchmaking) int {
+	log := logger.FromContext(ctx).With(slog.String("mode", mode))
+
+	moved := 0
+	for _, region := range prev.Regions {
+		if !next.HasRegion(region) {
+			if queued := queuedIn(ctx, redisClient, mode, []string{region}, prev.Tiers); queued > 0 {
+				log.Warn("Region removed with players queued", slog.String("region", region), slog.Int64("players", queued))
+			}
+			continue
+		}
+		for _, tier := range prev.Tiers.Names() {
+			queueName := GetQueueName(mode, region, tier)
+			vals, err := redisClient.ZRange(ctx, queueName, 0, -1).Result()
+			if err != nil {
+				log.Error("Error reading queue", slog.String("queue", queueName), slog.String("error", err.Error()))
+				continue
+			}
+			for _, v := range vals {
+				var p models.Player
+				if err := json.Unmarshal([]byte(v), &p); err != nil {
+					continue
+				}
+				target := GetQueueName(mode, region, next.Tiers.For(p.MMR))
+				if target == queueName {
+					continue
+				}
+
+				ok, err := moveScript.Run(ctx, redisClient, []string{queueName, target, queuedKey(p.ID)}, v, p.MMR).Int()
+				if err != nil {
+					log.Error("Failed to move player to new tier", slog.String("player_id", p.ID), slog.String("error", err.Error()))
+					continue
+				}
+				moved += ok
+			}
+		}
+	}
+	return moved
+}
+
+// queuedIn counts the players in a mode's queues for the given regions and tiers
+func queuedIn(ctx context.Context, redisClient *redis.Client, mode string, regions []string, tiers config.Tiers) int64 {
+	var queued int64
+	for _, region := range regions {
+		for _, tier := range tiers.Names() {
+			n, _ := redisClient.ZCard(ctx, GetQueueName(mode, region, tier)).Result()
+			queued += n
+		}
+	}
+	return queued
 }
@@ -0,0 +1,63 @@
+package replays
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/socket"
+)
+
+// Download serves the recorded replay file of a match
+func Download(db databases.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		matchID := r.PathValue("id")
+
+		replay, err := db.GetReplay(r.Context(), matchID)
+		if errors.Is(err, sql.ErrNoRows) {
+			response.WriteJson(w, http.StatusNotFound, response.GeneralError(fmt.Errorf("no replay for match %s", matchID)))
+			return
+		}
+		if err != nil {
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", matchID+".jsonl"))
+		http.ServeFile(w, r, replay.Path)
+	}
+}
+
+// Watch plays a recorded match back over a websocket, ?speed=1|2|4
+func Watch(db databases.Database, hub *socket.Hub, catalog *tracks.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		matchID := r.PathValue("id")
+
+		speed := 1
+		if s := r.URL.Query().Get("speed"); s != "" {
+			var err error
+			if speed, err = strconv.Atoi(s); err != nil || !socket.ValidReplaySpeed(speed) {
+				response.WriteJson(w, http.StatusBadRequest, response.GeneralError(socket.ErrInvalidReplaySpeed))
+				return
+			}
+		}
+
+		replay, err := db.GetReplay(r.Context(), matchID)
+		if errors.Is(err, sql.ErrNoRows) {
+			response.WriteJson(w, http.StatusNotFound, response.GeneralError(fmt.Errorf("no replay for match %s", matchID)))
+			return
+		}
+		if err != nil {
+			response.WriteJson(w, http.StatusInternalServerError, response.GeneralError(err))
+			return
+		}
+
+		socket.ServeReplayWs(hub, catalog, w, r, replay, speed)
+	}
+}
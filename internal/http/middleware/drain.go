@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+)
+
+// RejectWhileDraining turns new work away with 503 once draining reports true
+func RejectWhileDraining(draining func() bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if draining() {
+			w.Header().Set("Connection", "close")
+			response.WriteJson(w, http.StatusServiceUnavailable, response.GeneralError(errors.New("server is shutting down")))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID tags every request with an ID, taken from the X-Request-ID header when the
+// caller sent one, and puts a logger carrying it in the request context.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := logger.With(r.Context(), slog.String("request_id", id))
+		logger.FromContext(ctx).Debug("request received",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
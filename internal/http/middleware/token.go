@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/utils/response"
+)
+
+// RequireToken only lets requests carrying "Authorization: Bearer <token>" through
+func RequireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			response.WriteJson(w, http.StatusUnauthorized, response.GeneralError(errors.New("unauthorized")))
+			return
+		}
+		next(w, r)
+	}
+}
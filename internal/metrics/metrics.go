@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Matchmaking
+var (
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matchmaking_queue_depth",
+		Help: "Players waiting in each queue:<mode>:<region>:<tier> after the last matchmaking pass.",
+	}, []string{"mode", "region", "tier"})
+
+	TimeToMatch = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matchmaking_time_to_match_seconds",
+		Help:    "Time from joining the queue to being placed in a match.",
+		Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"mode", "region", "tier"})
+
+	// per minute is rate(matchmaking_matches_created_total[1m]) * 60
+	MatchesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matchmaking_matches_created_total",
+		Help: "Matches created by the matchmaker.",
+	}, []string{"mode", "region"})
+
+	MatchQuality = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matchmaking_match_quality",
+		Help:    "Quality score of the player pairs the matchmaker chose, 1 is a perfect pair.",
+		Buckets: []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1},
+	}, []string{"mode", "region"})
+)
+
+// Websocket hub
+var (
+	ConnectedClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hub_connected_clients",
+		Help: "Connected websocket clients per match.",
+	}, []string{"match_id", "kind"})
+
+	BroadcastBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hub_broadcast_backlog",
+		Help: "Messages waiting in Hub.broadcast.",
+	})
+
+	SlowConsumerDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hub_slow_consumer_disconnects_total",
+		Help: "Clients dropped because their send buffer was full.",
+	})
+)
+
+// Game loop
+var (
+	TickDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "game_tick_duration_seconds",
+		Help:    "Time spent on one game tick, including handing the snapshot to the hub.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+	}, []string{"match_id"})
+
+	TickOverruns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_tick_overruns_total",
+		Help: "Ticks that took longer than the tick interval.",
+	}, []string{"match_id"})
+
+	Collisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_collisions_total",
+		Help: "Overlaps resolved by the game loop, between two cars or a car and a wall.",
+	}, []string{"kind"})
+
+	CheatViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_cheat_violations_total",
+		Help: "Client inputs corrected or dropped by the anti-cheat checks, by kind of violation.",
+	}, []string{"kind"})
+
+	CheatEscalations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_cheat_escalations_total",
+		Help: "Players flagged for review or kicked from their match by the anti-cheat checks.",
+	}, []string{"action"})
+)
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	IncidentFlagged = "flagged" // the player's suspicion score reached the flag threshold
+	IncidentKicked  = "kicked"  // the player was removed from the match
+)
+
+// CheatIncident is a player the game's input checks escalated, kept for review
+type CheatIncident struct {
+	ID         int64          `json:"id"`
+	MatchID    string         `json:"match_id"`
+	PlayerID   string         `json:"player_id"`
+	Tick       int64          `json:"tick"`
+	Action     string         `json:"action"`
+	Score      float64        `json:"score"`
+	Violations map[string]int `json:"violations"` // inputs corrected or dropped so far, by kind
+	Detail     string         `json:"detail"`     // the violation that tipped it over
+	CreatedAt  time.Time      `json:"created_at"`
+}
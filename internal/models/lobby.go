@@ -0,0 +1,45 @@
+package models
+
+const (
+	LobbyStatusOpen     = "open"
+	LobbyStatusStarting = "starting" // a start is reserving the match
+	LobbyStatusStarted  = "started"
+)
+
+// LobbySettings is what the host chose for the race
+type LobbySettings struct {
+	Track      string `json:"track"`
+	Laps       int    `json:"laps"`
+	MaxPlayers int    `json:"max_players"`
+}
+
+// Lobby is a private match players join by code instead of through the queue
+type Lobby struct {
+	Code      string        `json:"code"`
+	Host      string        `json:"host"`
+	Settings  LobbySettings `json:"settings"`
+	Players   []string      `json:"players"`          // host first, then in join order
+	Kicked    []string      `json:"kicked,omitempty"` // may not join again
+	Status    string        `json:"status"`
+	MatchID   string        `json:"match_id,omitempty"`
+	Endpoint  string        `json:"endpoint,omitempty"` // websocket URL of the started match
+	CreatedAt int64         `json:"created_at"`
+}
+
+func (l Lobby) Has(playerID string) bool {
+	for _, id := range l.Players {
+		if id == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (l Lobby) WasKicked(playerID string) bool {
+	for _, id := range l.Kicked {
+		if id == playerID {
+			return true
+		}
+	}
+	return false
+}
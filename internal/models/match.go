@@ -1,8 +1,71 @@
 package models
 
+const (
+	MatchStatusLive      = "live"
+	MatchStatusFinished  = "finished"
+	MatchStatusAbandoned = "abandoned" // every player left
+	MatchStatusAborted   = "aborted"   // the server stopped the match
+)
+
 type Match struct {
-	ID      string   `json:"id"`
-	Players []string `json:"players"`
-	Region  string   `json:"region"`
-	Status  string   `json:"status"`
+	ID            string      `json:"id"`
+	Players       []string    `json:"players"`
+	Bots          []string    `json:"bots,omitempty"` // subset of Players driven by the server
+	BotDifficulty string      `json:"bot_difficulty,omitempty"`
+	Region        string      `json:"region"`
+	Status        string      `json:"status"`
+	NodeID        string      `json:"node_id,omitempty"`
+	Endpoint      string      `json:"endpoint,omitempty"`      // websocket URL of the game node hosting the match
+	RulesVersion  int64       `json:"rules_version,omitempty"` // matchmaking rules the match was made under
+	CrossRegion   bool        `json:"cross_region,omitempty"`  // at least one player was placed outside their home region
+	Placements    []Placement `json:"placements,omitempty"`
+	Mode          string      `json:"mode,omitempty"`
+	Teams         [][]string  `json:"teams,omitempty"` // player IDs, bots included, by team
+	Lobby         string      `json:"lobby,omitempty"` // join code of the private lobby the match was started from
+	Track         string      `json:"track,omitempty"`
+	Laps          int         `json:"laps,omitempty"`
+}
+
+// Ranked reports whether the match's results count towards ratings. Lobby
+// matches are played between friends and never do.
+func (m Match) Ranked() bool {
+	return m.Lobby == ""
+}
+
+// Placement records where the matchmaker put a player and the ping it relied on
+type Placement struct {
+	PlayerID    string `json:"player_id"`
+	HomeRegion  string `json:"home_region"`
+	Ping        int    `json:"ping"` // to the match region
+	CrossRegion bool   `json:"cross_region,omitempty"`
+}
+
+func (m Match) Placement(playerID string) (Placement, bool) {
+	for _, p := range m.Placements {
+		if p.PlayerID == playerID {
+			return p, true
+		}
+	}
+	return Placement{}, false
+}
+
+// Team returns the index of the player's team, -1 if they are not on one
+func (m Match) Team(playerID string) int {
+	for i, team := range m.Teams {
+		for _, id := range team {
+			if id == playerID {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (m Match) IsBot(playerID string) bool {
+	for _, id := range m.Bots {
+		if id == playerID {
+			return true
+		}
+	}
+	return false
 }
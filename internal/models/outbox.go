@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	OutboxMatchCreated = "match_created" // payload is a MatchCreatedEffects
+)
+
+// OutboxMessage is a side effect committed together with the database change
+// that caused it, applied afterwards by a relay
+type OutboxMessage struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Payload   []byte    `json:"payload"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MatchCreatedEffects is what has to happen outside the database once a match is committed
+type MatchCreatedEffects struct {
+	Match   Match    `json:"match"`
+	Tier    string   `json:"tier"`
+	Queue   string   `json:"queue"`
+	Members []string `json:"members"`          // the players' queue entries, exactly as stored in Redis
+	Queues  []string `json:"queues,omitempty"` // queue of each member, when not all in Queue
+	Tiers   []string `json:"tiers,omitempty"`  // tier of each member, when not all in Tier
+}
@@ -5,5 +5,19 @@ type Player struct{
 	MMR int `json:"mmr"`
 	Region string `json:"region"`
 	Ping int `json:"ping"`
+	Latency map[string]int `json:"latency,omitempty"` // measured ping in ms to each region the client could reach
+	Mode string `json:"mode,omitempty"`
+	Ratings map[string]int `json:"ratings,omitempty"` // rating in each rating pool, MMR is taken from the mode's when given
 	JoinedAt int64 `json:"joined_at"`
 }
+
+// PingTo returns the player's ping to a region and whether it is known
+func (p Player) PingTo(region string) (int, bool){
+	if ping, ok := p.Latency[region]; ok{
+		return ping, true
+	}
+	if region == p.Region{
+		return p.Ping, true
+	}
+	return 0, false
+}
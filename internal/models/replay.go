@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Replay indexes a recorded match file on disk
+type Replay struct {
+	MatchID   string    `json:"match_id"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
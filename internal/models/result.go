@@ -0,0 +1,21 @@
+package models
+
+// PlayerResult is where a participant finished, or got to if the match was cut short
+type PlayerResult struct {
+	MatchID  string  `json:"match_id"`
+	PlayerID string  `json:"player_id"`
+	Bot      bool    `json:"bot"`
+	Tick     int64   `json:"tick"` // game tick the result was taken at
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Damaged  bool    `json:"damaged"`
+	Partial  bool    `json:"partial"` // the match did not run to completion
+	Ranked   bool    `json:"ranked"`  // counts towards ratings, lobby matches never do
+
+	// Race standing, left empty when the match had no track. Times are in milliseconds.
+	Position int     `json:"position,omitempty"`
+	LapTimes []int64 `json:"lap_times,omitempty"` // of each lap completed
+	BestLap  int64   `json:"best_lap,omitempty"`
+	Finished bool    `json:"finished,omitempty"`
+	RaceTime int64   `json:"race_time,omitempty"` // only once finished
+}
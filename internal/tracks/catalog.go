@@ -0,0 +1,79 @@
+package tracks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Catalog is every track the server can race on, loaded once at startup
+type Catalog struct {
+	Default string // track of matches that don't name one
+	tracks  map[string]*Track
+}
+
+// LoadDir reads every .json file in dir as a track. Any invalid file fails the
+// whole load, so a broken track is found at startup rather than mid-race.
+func LoadDir(dir, defaultTrack string) (*Catalog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{Default: defaultTrack, tracks: make(map[string]*Track, len(files))}
+	for _, path := range files {
+		t, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := c.tracks[t.Name]; exists {
+			return nil, fmt.Errorf("track %s is defined twice, again in %s", t.Name, path)
+		}
+		c.tracks[t.Name] = t
+	}
+
+	if _, ok := c.tracks[defaultTrack]; !ok {
+		return nil, fmt.Errorf("default track %q not found in %s", defaultTrack, dir)
+	}
+	return c, nil
+}
+
+func loadFile(path string) (*Track, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Track
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := t.prepare(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Get returns a track by name, "" being the default track
+func (c *Catalog) Get(name string) (*Track, bool) {
+	if name == "" {
+		name = c.Default
+	}
+	t, ok := c.tracks[name]
+	return t, ok
+}
+
+// Names lists the tracks in alphabetical order
+func (c *Catalog) Names() []string {
+	names := make([]string, 0, len(c.tracks))
+	for name := range c.tracks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,188 @@
+package tracks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Point is a position in world units, written as [x, y] in track files
+type Point struct {
+	X, Y float64
+}
+
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]float64{p.X, p.Y})
+}
+
+func (p *Point) UnmarshalJSON(b []byte) error {
+	var xy [2]float64
+	if err := json.Unmarshal(b, &xy); err != nil {
+		return err
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+// Segment is a straight line between two points, a wall or a gate cars cross
+type Segment struct {
+	A Point `json:"a"`
+	B Point `json:"b"`
+}
+
+func (s Segment) Midpoint() Point {
+	return Point{(s.A.X + s.B.X) / 2, (s.A.Y + s.B.Y) / 2}
+}
+
+// Crosses reports whether moving from p to q crosses the segment. Touching the
+// segment at q counts, touching it at p doesn't, so a car stopping exactly on a
+// gate crosses it once.
+func (s Segment) Crosses(p, q Point) bool {
+	d1, d2 := orient(s.A, s.B, p), orient(s.A, s.B, q)
+	if d1 == 0 || d2 != 0 && (d1 > 0) == (d2 > 0) {
+		return false // p is on the line, or both ends are on the same side
+	}
+	if d2 == 0 {
+		return onSegment(s, q)
+	}
+	d3, d4 := orient(p, q, s.A), orient(p, q, s.B)
+	return !(d3 > 0 && d4 > 0) && !(d3 < 0 && d4 < 0)
+}
+
+// Closest returns the point of the segment nearest to p
+func (s Segment) Closest(p Point) Point {
+	dx, dy := s.B.X-s.A.X, s.B.Y-s.A.Y
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((p.X-s.A.X)*dx+(p.Y-s.A.Y)*dy)/l))
+	}
+	return Point{s.A.X + t*dx, s.A.Y + t*dy}
+}
+
+// Distance is how far p is from the closest point of the segment
+func (s Segment) Distance(p Point) float64 {
+	c := s.Closest(p)
+	return math.Hypot(p.X-c.X, p.Y-c.Y)
+}
+
+// GridSlot is where and facing which way a car starts. Angle is in radians, 0
+// pointing along +X like CarState.Angle.
+type GridSlot struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Angle float64 `json:"angle"`
+}
+
+/*
+Track is a circuit loaded from a data file. The driveable area is given either as
+a closed centreline and a width, or as the two closed boundary polygons:
+
+	{"name": "oval", "laps": 3, "centreline": [[300, 0], [277, 77], ...], "width": 80,
+	 "grid": [{"x": 315, "y": -30, "angle": 1.5708}, ...]}
+
+Checkpoints are gates cars have to cross in order, the first one being the start
+and finish line. A centreline track without checkpoints gets a gate across every
+centreline point.
+*/
+type Track struct {
+	Name        string     `json:"name"` // defaults to the file name
+	Laps        int        `json:"laps"` // race length unless the match sets its own
+	Centreline  []Point    `json:"centreline,omitempty"`
+	Width       float64    `json:"width,omitempty"`
+	Left        []Point    `json:"left,omitempty"` // boundary on the left of the racing direction
+	Right       []Point    `json:"right,omitempty"`
+	Checkpoints []Segment  `json:"checkpoints"`
+	Grid        []GridSlot `json:"grid"` // first slot is pole position
+}
+
+// prepare checks a freshly loaded track and fills in what the file left to the centreline
+func (t *Track) prepare() error {
+	if t.Name == "" {
+		return errors.New("track has no name")
+	}
+	if t.Laps < 1 {
+		return fmt.Errorf("track %s: laps must be at least 1", t.Name)
+	}
+	if len(t.Grid) == 0 {
+		return fmt.Errorf("track %s: no grid slots", t.Name)
+	}
+
+	switch {
+	case len(t.Centreline) > 0:
+		if len(t.Centreline) < 3 || t.Width <= 0 {
+			return fmt.Errorf("track %s: a centreline needs at least 3 points and a width", t.Name)
+		}
+		if len(t.Left) > 0 || len(t.Right) > 0 {
+			return fmt.Errorf("track %s: give either a centreline or boundaries, not both", t.Name)
+		}
+		gates := make([]Segment, len(t.Centreline))
+		for i, p := range t.Centreline {
+			prev := t.Centreline[(i+len(t.Centreline)-1)%len(t.Centreline)]
+			next := t.Centreline[(i+1)%len(t.Centreline)]
+			dx, dy := next.X-prev.X, next.Y-prev.Y
+			l := math.Hypot(dx, dy)
+			if l == 0 {
+				return fmt.Errorf("track %s: centreline point %d repeats its neighbours", t.Name, i)
+			}
+			// normal pointing left of the racing direction, half a track wide
+			nx, ny := -dy/l*t.Width/2, dx/l*t.Width/2
+			gates[i] = Segment{A: Point{p.X + nx, p.Y + ny}, B: Point{p.X - nx, p.Y - ny}}
+			t.Left = append(t.Left, gates[i].A)
+			t.Right = append(t.Right, gates[i].B)
+		}
+		if len(t.Checkpoints) == 0 {
+			t.Checkpoints = gates
+		}
+	case len(t.Left) >= 3 && len(t.Right) >= 3:
+		if len(t.Checkpoints) == 0 {
+			return fmt.Errorf("track %s: tracks given by their boundaries need checkpoints", t.Name)
+		}
+	default:
+		return fmt.Errorf("track %s: needs a centreline or both boundaries", t.Name)
+	}
+
+	if len(t.Checkpoints) < 2 {
+		return fmt.Errorf("track %s: needs at least 2 checkpoints", t.Name)
+	}
+	return nil
+}
+
+// Waypoints is the line bots drive: the centreline, or the middle of each checkpoint
+func (t *Track) Waypoints() []Point {
+	if len(t.Centreline) > 0 {
+		return t.Centreline
+	}
+	points := make([]Point, len(t.Checkpoints))
+	for i, gate := range t.Checkpoints {
+		points[i] = gate.Midpoint()
+	}
+	return points
+}
+
+// Walls returns the edges of both boundaries, each boundary being a closed loop
+func (t *Track) Walls() []Segment {
+	walls := make([]Segment, 0, len(t.Left)+len(t.Right))
+	for _, boundary := range [][]Point{t.Left, t.Right} {
+		for i, p := range boundary {
+			walls = append(walls, Segment{A: p, B: boundary[(i+1)%len(boundary)]})
+		}
+	}
+	return walls
+}
+
+// Slot returns the grid slot of the i-th car on the grid. The grid starts over
+// when more cars race than it has room for.
+func (t *Track) Slot(i int) GridSlot {
+	return t.Grid[i%len(t.Grid)]
+}
+
+// orient is positive when c is left of a->b, negative when right and 0 when on the line
+func orient(a, b, c Point) float64 {
+	return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+}
+
+func onSegment(s Segment, p Point) bool {
+	return math.Min(s.A.X, s.B.X) <= p.X && p.X <= math.Max(s.A.X, s.B.X) &&
+		math.Min(s.A.Y, s.B.Y) <= p.Y && p.Y <= math.Max(s.A.Y, s.B.Y)
+}
@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"fmt"
+	"log/slog"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -9,14 +9,13 @@ import (
 var client *redis.Client
 
 func SetClient(redisClient *redis.Client){
-	fmt.Println("Redis Client Set")
 	client = redisClient
-	fmt.Println("Redis Client is initialized")
+	slog.Info("Redis Client is initialized")
 }
 
 func GetClient() *redis.Client{
 	if client == nil{
-		fmt.Println("Warning : Redis Client is nil")
+		slog.Warn("Redis Client is nil")
 	}
 	return client
 }
@@ -0,0 +1,183 @@
+package socket
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+)
+
+// AntiCheatOptions bounds what a client may claim its car did between inputs
+type AntiCheatOptions struct {
+	Tolerance      float64 // share over MaxSpeed allowed for timing jitter
+	Slack          float64 // distance a car may be off on top of that, for collision push-outs
+	MaxTurnRate    float64 // radians per tick
+	TeleportFactor float64 // moves this many times too far are dropped instead of clamped
+	FlagScore      float64 // suspicion at which a player is flagged for review
+	KickScore      float64 // suspicion at which a player is removed from the match
+	Decay          float64 // suspicion forgiven per second
+}
+
+// Kinds of violation, and how much suspicion each adds
+const (
+	violationInvalid  = "invalid"  // a value that isn't a finite number, dropped
+	violationSpeed    = "speed"    // faster than MaxSpeed, clamped
+	violationTurn     = "turn"     // turned faster than MaxTurnRate, clamped
+	violationDistance = "distance" // moved further than its speed allows, clamped
+	violationTeleport = "teleport" // moved TeleportFactor times too far, dropped
+)
+
+var violationWeights = map[string]float64{
+	violationInvalid:  5,
+	violationSpeed:    1,
+	violationTurn:     1,
+	violationDistance: 2,
+	violationTeleport: 5,
+}
+
+// inspector checks each client input against the car it moves, corrects or drops
+// what the car couldn't have done, and keeps a suspicion score per player that
+// decays while they play clean. Bots are never inspected. Not safe for concurrent
+// use, games call it under their lock.
+type inspector struct {
+	opts      AntiCheatOptions
+	perSecond int64 // ticks in a second, also the most an input may cover
+	players   map[string]*suspect
+}
+
+type suspect struct {
+	lastTick int64   // tick of the last input let through
+	window   int64   // tick the movement budget was set for
+	elapsed  float64 // ticks of movement the budget covers
+	// where the car was when the budget was set, every input that tick is measured from there
+	fromX, fromY, fromAngle float64
+
+	score      float64
+	scoredAt   int64 // tick the score was last decayed to
+	flagged    bool
+	violations map[string]int
+}
+
+// verdict is what to do with one input
+type verdict struct {
+	input      PlayerInput // corrected, apply this instead of what was sent
+	drop       bool
+	violations []string
+	detail     string // what the last violation was, for the incident
+	escalate   string // models.IncidentFlagged or models.IncidentKicked when the score crossed a threshold
+	score      float64
+	counts     map[string]int // violations by kind so far, a copy
+}
+
+func newInspector(opts AntiCheatOptions, tickInterval time.Duration) *inspector {
+	return &inspector{
+		opts:      opts,
+		perSecond: max(1, int64(time.Second/tickInterval)),
+		players:   make(map[string]*suspect),
+	}
+}
+
+// check compares an input with the car it moves, as of tick. Inputs sharing a tick
+// are all measured from where the car was before the first of them, so sending
+// more of them doesn't move the car any further.
+func (in *inspector) check(tick int64, car *CarState, input PlayerInput) verdict {
+	s, ok := in.players[input.PlayerID]
+	if !ok {
+		s = &suspect{lastTick: tick - 1, window: -1, scoredAt: tick, violations: make(map[string]int)}
+		in.players[input.PlayerID] = s
+	}
+
+	v := verdict{input: input}
+	next := &v.input.Payload
+	violate := func(kind, format string, args ...any) {
+		v.violations = append(v.violations, kind)
+		v.detail = kind + ": " + fmt.Sprintf(format, args...)
+	}
+
+	// one budget per tick, however many inputs share it, and going quiet doesn't
+	// save up distance to jump later
+	if tick != s.window {
+		s.window = tick
+		s.elapsed = float64(min(in.perSecond, max(1, tick-s.lastTick)))
+		s.fromX, s.fromY, s.fromAngle = car.X, car.Y, car.Angle
+	}
+	elapsed := s.elapsed
+
+	switch {
+	case !finite(next.X, next.Y, next.Speed, next.Angle):
+		violate(violationInvalid, "x=%v y=%v speed=%v angle=%v", next.X, next.Y, next.Speed, next.Angle)
+		v.drop = true
+	default:
+		limit := car.MaxSpeed * (1 + in.opts.Tolerance)
+		if math.Abs(next.Speed) > limit {
+			violate(violationSpeed, "%.2f over a max of %.2f", next.Speed, car.MaxSpeed)
+			next.Speed = math.Copysign(car.MaxSpeed, next.Speed)
+		}
+
+		// math.Remainder rather than normalizeAngle, which loops on huge angles
+		maxTurn := in.opts.MaxTurnRate * elapsed
+		turn := math.Remainder(next.Angle-s.fromAngle, 2*math.Pi)
+		if math.Abs(turn) > maxTurn {
+			violate(violationTurn, "%.2f rad in %.0f ticks", turn, elapsed)
+			turn = math.Copysign(maxTurn, turn)
+		}
+		next.Angle = normalizeAngle(s.fromAngle + turn)
+
+		allowed := limit*elapsed + in.opts.Slack
+		dx, dy := next.X-s.fromX, next.Y-s.fromY
+		switch d := math.Hypot(dx, dy); {
+		case d > allowed*in.opts.TeleportFactor:
+			violate(violationTeleport, "%.1f in %.0f ticks, allowed %.1f", d, elapsed, allowed)
+			v.drop = true
+		case d > allowed:
+			violate(violationDistance, "%.1f in %.0f ticks, allowed %.1f", d, elapsed, allowed)
+			next.X = s.fromX + dx/d*allowed
+			next.Y = s.fromY + dy/d*allowed
+		}
+	}
+
+	if !v.drop {
+		s.lastTick = tick
+	}
+	in.decay(s, tick)
+	if len(v.violations) == 0 {
+		return v
+	}
+
+	for _, kind := range v.violations {
+		s.score += violationWeights[kind]
+		s.violations[kind]++
+	}
+	switch {
+	case s.score >= in.opts.KickScore:
+		v.escalate = models.IncidentKicked
+		v.drop = true
+	case s.score >= in.opts.FlagScore && !s.flagged:
+		v.escalate = models.IncidentFlagged
+		s.flagged = true
+	}
+	v.score = s.score
+	v.counts = make(map[string]int, len(s.violations))
+	for kind, n := range s.violations {
+		v.counts[kind] = n
+	}
+	return v
+}
+
+// decay forgives the suspicion earned since the score was last looked at. A
+// flagged player stays flagged, only the kick gets further away.
+func (in *inspector) decay(s *suspect, tick int64) {
+	seconds := float64(tick-s.scoredAt) / float64(in.perSecond)
+	s.score = math.Max(0, s.score-seconds*in.opts.Decay)
+	s.scoredAt = tick
+}
+
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
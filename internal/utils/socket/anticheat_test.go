@@ -0,0 +1,234 @@
+package socket
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+)
+
+// testAntiCheat allows a car with MaxSpeed 10 to move 10*1.1+2 = 13 in a tick,
+// and 65 before a move counts as a teleport. Ticks are 50ms, 20 to the second.
+var testAntiCheat = AntiCheatOptions{
+	Tolerance:      0.1,
+	Slack:          2,
+	MaxTurnRate:    0.2,
+	TeleportFactor: 5,
+	FlagScore:      4,
+	KickScore:      10,
+	Decay:          1,
+}
+
+const testTickInterval = 50 * time.Millisecond
+
+// testInput is an input from player "p" claiming the car is now at x, y
+func testInput(x, y, angle, speed float64) PlayerInput {
+	return PlayerInput{PlayerID: "p", Payload: CarState{X: x, Y: y, Angle: angle, Speed: speed}}
+}
+
+func TestInspectorCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		car        *CarState
+		input      PlayerInput
+		want       CarState // X, Y, Angle and Speed of the corrected input
+		violations []string
+		drop       bool
+	}{
+		{
+			name:  "a move within the limits is let through",
+			car:   testCar(0, 0, 0, 0),
+			input: testInput(10, 0, 0.1, 10),
+			want:  CarState{X: 10, Y: 0, Angle: 0.1, Speed: 10},
+		},
+		{
+			name:       "too fast is clamped to MaxSpeed",
+			car:        testCar(0, 0, 0, 0),
+			input:      testInput(0, 0, 0, 12),
+			want:       CarState{Speed: 10},
+			violations: []string{violationSpeed},
+		},
+		{
+			name:       "too fast in reverse is clamped to -MaxSpeed",
+			car:        testCar(0, 0, 0, 0),
+			input:      testInput(0, 0, 0, -12),
+			want:       CarState{Speed: -10},
+			violations: []string{violationSpeed},
+		},
+		{
+			name:       "turning too fast is clamped to MaxTurnRate",
+			car:        testCar(0, 0, 0, 0),
+			input:      testInput(0, 0, 1, 0),
+			want:       CarState{Angle: 0.2},
+			violations: []string{violationTurn},
+		},
+		{
+			name:  "a turn across pi is measured the short way round",
+			car:   testCar(0, 0, math.Pi-0.05, 0),
+			input: testInput(0, 0, -math.Pi+0.05, 0),
+			want:  CarState{Angle: normalizeAngle(math.Pi + 0.05)},
+		},
+		{
+			name:       "moving too far is clamped to the distance allowed",
+			car:        testCar(0, 0, 0, 0),
+			input:      testInput(20, 0, 0, 10),
+			want:       CarState{X: 13, Speed: 10},
+			violations: []string{violationDistance},
+		},
+		{
+			name:       "a teleport is dropped",
+			car:        testCar(0, 0, 0, 0),
+			input:      testInput(100, 0, 0, 10),
+			violations: []string{violationTeleport},
+			drop:       true,
+		},
+		{
+			name:       "a value that isn't a number is dropped",
+			car:        testCar(0, 0, 0, 0),
+			input:      testInput(math.NaN(), 0, 0, 0),
+			violations: []string{violationInvalid},
+			drop:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := newInspector(testAntiCheat, testTickInterval)
+			v := in.check(1, tt.car, tt.input)
+			if !reflect.DeepEqual(v.violations, tt.violations) {
+				t.Errorf("violations = %v, want %v", v.violations, tt.violations)
+			}
+			if v.drop != tt.drop {
+				t.Errorf("drop = %v, want %v", v.drop, tt.drop)
+			}
+			if tt.drop {
+				return
+			}
+			got := v.input.Payload
+			if !near(got.X, tt.want.X) || !near(got.Y, tt.want.Y) || !near(got.Angle, tt.want.Angle) || !near(got.Speed, tt.want.Speed) {
+				t.Errorf("corrected to x=%v y=%v angle=%v speed=%v, want x=%v y=%v angle=%v speed=%v",
+					got.X, got.Y, got.Angle, got.Speed, tt.want.X, tt.want.Y, tt.want.Angle, tt.want.Speed)
+			}
+		})
+	}
+}
+
+func TestInspectorSharesOneBudgetPerTick(t *testing.T) {
+	in := newInspector(testAntiCheat, testTickInterval)
+	car := testCar(0, 0, 0, 0)
+	state := &GameState{Players: map[string]*CarState{"p": car}}
+
+	// every input on its own is within a tick's reach of where the previous one put the car
+	var violations int
+	for i := 0; i < 10; i++ {
+		v := in.check(1, car, testInput(car.X+13, 0, car.Angle+0.2, 10))
+		if i == 0 && len(v.violations) > 0 {
+			t.Fatalf("first input of the tick flagged: %v", v.violations)
+		}
+		violations += len(v.violations)
+		if !v.drop {
+			applyInput(state, v.input)
+		}
+	}
+	if car.X > 13+1e-9 {
+		t.Errorf("ten inputs in one tick moved the car %v, at most 13 is allowed", car.X)
+	}
+	if car.Angle > 0.2+1e-9 {
+		t.Errorf("ten inputs in one tick turned the car %v, at most 0.2 is allowed", car.Angle)
+	}
+	if violations == 0 {
+		t.Error("extra inputs in the tick were never counted as violations")
+	}
+
+	// the next tick brings a fresh budget
+	start := car.X
+	if v := in.check(2, car, testInput(start+13, 0, car.Angle, 10)); len(v.violations) > 0 {
+		t.Errorf("a full move on the next tick was flagged: %v", v.violations)
+	}
+}
+
+func TestInspectorBudgetAfterSilence(t *testing.T) {
+	in := newInspector(testAntiCheat, testTickInterval)
+	car := testCar(0, 0, 0, 0)
+	in.check(1, car, testInput(0, 0, 0, 0))
+
+	// a player quiet for 100 ticks gets a second's worth of movement, no more
+	v := in.check(101, car, testInput(300, 0, 0, 10))
+	if !reflect.DeepEqual(v.violations, []string{violationDistance}) {
+		t.Fatalf("violations = %v, want a distance violation", v.violations)
+	}
+	if want := 11.0*20 + 2; !near(v.input.Payload.X, want) {
+		t.Errorf("clamped to x=%v, want %v", v.input.Payload.X, want)
+	}
+}
+
+func TestInspectorEscalation(t *testing.T) {
+	in := newInspector(testAntiCheat, testTickInterval)
+	car := testCar(0, 0, 0, 0)
+	teleport := testInput(1000, 0, 0, 10)
+
+	steps := []struct {
+		tick     int64
+		input    PlayerInput
+		escalate string
+		score    float64
+	}{
+		{tick: 1, input: teleport, escalate: models.IncidentFlagged, score: 5},
+		{tick: 2, input: teleport, escalate: "", score: 9.95}, // flagged once only
+		{tick: 3, input: teleport, escalate: models.IncidentKicked, score: 14.9},
+	}
+	for _, step := range steps {
+		v := in.check(step.tick, car, step.input)
+		if !v.drop {
+			t.Fatalf("tick %d: teleport let through", step.tick)
+		}
+		if v.escalate != step.escalate {
+			t.Fatalf("tick %d: escalate = %q, want %q", step.tick, v.escalate, step.escalate)
+		}
+		if !near(v.score, step.score) {
+			t.Fatalf("tick %d: score = %v, want %v", step.tick, v.score, step.score)
+		}
+	}
+}
+
+func TestInspectorScoreDecays(t *testing.T) {
+	in := newInspector(testAntiCheat, testTickInterval)
+	car := testCar(0, 0, 0, 0)
+
+	in.check(1, car, testInput(1000, 0, 0, 10)) // teleport, 5
+	// ten clean seconds later the score has decayed away
+	v := in.check(201, car, testInput(0, 0, 0, 12)) // speed, 1
+	if !near(v.score, 1) {
+		t.Errorf("score = %v, want 1", v.score)
+	}
+	if v.counts[violationTeleport] != 1 || v.counts[violationSpeed] != 1 {
+		t.Errorf("counts = %v, the violations themselves are kept", v.counts)
+	}
+}
+
+func TestGameHandleInputSameTick(t *testing.T) {
+	opts := testAntiCheat
+	opts.KickScore = math.Inf(1) // kicking needs a hub
+	car := testCar(0, 0, 0, 0)
+	g := &Game{
+		State:     GameState{Tick: 1, Players: map[string]*CarState{"p": car}},
+		inspector: newInspector(opts, testTickInterval),
+		kicked:    make(map[string]bool),
+		log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	for i := 0; i < 20; i++ {
+		g.handleInput(testInput(car.X+13, 0, 0, 10))
+	}
+	if car.X > 13+1e-9 {
+		t.Errorf("twenty inputs in one tick moved the car %v, at most 13 is allowed", car.X)
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,129 @@
+package socket
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+)
+
+const (
+	BotEasy   = "easy"
+	BotMedium = "medium"
+	BotHard   = "hard"
+)
+
+type botProfile struct {
+	speedFactor float64 // fraction of MaxSpeed the bot aims for
+	turnRate    float64 // max radians the bot steers per tick
+	noise       float64 // random steering error in radians
+}
+
+var botProfiles = map[string]botProfile{
+	BotEasy:   {speedFactor: 0.6, turnRate: 0.06, noise: 0.20},
+	BotMedium: {speedFactor: 0.8, turnRate: 0.10, noise: 0.08},
+	BotHard:   {speedFactor: 1.0, turnRate: 0.15, noise: 0.02},
+}
+
+func ValidBotDifficulty(difficulty string) bool {
+	_, ok := botProfiles[difficulty]
+	return ok
+}
+
+// defaultWaypoints is an oval circuit bots follow in games without a track
+var defaultWaypoints = func() []tracks.Point {
+	points := make([]tracks.Point, 16)
+	for i := range points {
+		a := 2 * math.Pi * float64(i) / float64(len(points))
+		points[i] = tracks.Point{X: 300 * math.Cos(a), Y: 200 * math.Sin(a)}
+	}
+	return points
+}()
+
+// waypointRadius is how close a bot has to get before heading to the next waypoint
+const waypointRadius = 25
+
+// Bot is a server-side driver that produces a PlayerInput every tick.
+// It takes part in the game like any connected player.
+type Bot struct {
+	PlayerID   string
+	Difficulty string
+	profile    botProfile
+	waypoints  []tracks.Point
+	next       int
+	rng        *rand.Rand
+}
+
+// NewBot creates a bot that laps the waypoints in order, the default oval when there are none
+func NewBot(playerID, difficulty string, waypoints []tracks.Point) *Bot {
+	profile, ok := botProfiles[difficulty]
+	if !ok {
+		difficulty = BotMedium
+		profile = botProfiles[BotMedium]
+	}
+
+	// seed from the ID so a bot drives the same way every time
+	h := fnv.New64a()
+	h.Write([]byte(playerID))
+
+	if len(waypoints) == 0 {
+		waypoints = defaultWaypoints
+	}
+
+	return &Bot{
+		PlayerID:   playerID,
+		Difficulty: difficulty,
+		profile:    profile,
+		waypoints:  waypoints,
+		rng:        rand.New(rand.NewSource(int64(h.Sum64()))),
+	}
+}
+
+// NextInput steers the car towards the next waypoint and moves it one tick.
+// Angle is in radians, 0 pointing along +X.
+func (b *Bot) NextInput(car CarState) PlayerInput {
+	target := b.waypoints[b.next]
+	dx, dy := target.X-car.X, target.Y-car.Y
+	if math.Hypot(dx, dy) < waypointRadius {
+		b.next = (b.next + 1) % len(b.waypoints)
+		target = b.waypoints[b.next]
+		dx, dy = target.X-car.X, target.Y-car.Y
+	}
+
+	// steer towards the target, limited by how fast this bot can turn
+	diff := normalizeAngle(math.Atan2(dy, dx) - car.Angle)
+	diff = math.Max(-b.profile.turnRate, math.Min(b.profile.turnRate, diff))
+	angle := normalizeAngle(car.Angle + diff + (b.rng.Float64()*2-1)*b.profile.noise)
+
+	// ease off in sharp corners, accelerate on straights
+	targetSpeed := car.MaxSpeed * b.profile.speedFactor * (1 - math.Abs(diff)/math.Pi)
+	speed := car.Speed
+	if speed < targetSpeed {
+		speed = math.Min(targetSpeed, speed+car.Acceleration)
+	} else {
+		speed = math.Max(targetSpeed, speed-car.Friction)
+	}
+
+	next := car
+	next.Angle = angle
+	next.Speed = speed
+	next.X += math.Cos(angle) * speed
+	next.Y += math.Sin(angle) * speed
+
+	return PlayerInput{
+		PlayerID: b.PlayerID,
+		Action:   "drive",
+		Payload:  next,
+	}
+}
+
+func normalizeAngle(a float64) float64 {
+	for a > math.Pi {
+		a -= 2 * math.Pi
+	}
+	for a < -math.Pi {
+		a += 2 * math.Pi
+	}
+	return a
+}
@@ -0,0 +1,305 @@
+package socket
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+)
+
+const (
+	// cellSize is the side of a broad phase grid cell, a bit more than a car's
+	// diagonal so a car spans at most four cells
+	cellSize = 64.0
+
+	// collisionSpeedKeep is the share of its speed a car keeps after hitting something
+	collisionSpeedKeep = 0.5
+
+	// damagePerImpact is the damage taken per unit of closing speed
+	damagePerImpact = 4.0
+
+	// damageLimit is the damage at which a car is Damaged and its MaxSpeed is cut
+	// to damagedSpeedFactor of what it was
+	damageLimit        = 100.0
+	damagedSpeedFactor = 0.6
+
+	// contactSlop is how far boxes may overlap and still count as only touching,
+	// so cars left exactly in contact by a push-out don't hit again next tick
+	contactSlop = 1e-6
+)
+
+// contacts counts what a collision step resolved
+type contacts struct {
+	cars, walls int
+}
+
+// collider resolves cars overlapping each other and the track walls once a tick.
+// Boxes are only tested when they share a cell of a uniform grid, and everything
+// is visited in player ID order, so a tick resolves the same way every time it is
+// replayed.
+type collider struct {
+	walls     []tracks.Segment
+	wallCells map[cell][]int // walls passing through each cell, built once
+}
+
+type cell struct {
+	x, y int
+}
+
+func cellOf(x, y float64) cell {
+	return cell{int(math.Floor(x / cellSize)), int(math.Floor(y / cellSize))}
+}
+
+// newCollider prepares collisions against the track's walls, track may be nil
+func newCollider(track *tracks.Track) *collider {
+	c := &collider{wallCells: make(map[cell][]int)}
+	if track == nil {
+		return c
+	}
+	c.walls = track.Walls()
+	for i, wall := range c.walls {
+		lo := cellOf(math.Min(wall.A.X, wall.B.X), math.Min(wall.A.Y, wall.B.Y))
+		hi := cellOf(math.Max(wall.A.X, wall.B.X), math.Max(wall.A.Y, wall.B.Y))
+		for x := lo.x; x <= hi.x; x++ {
+			for y := lo.y; y <= hi.y; y++ {
+				c.wallCells[cell{x, y}] = append(c.wallCells[cell{x, y}], i)
+			}
+		}
+	}
+	return c
+}
+
+// step pushes overlapping cars apart, slows and damages them by how hard they hit,
+// and keeps every car within its MaxSpeed. Ghost cars only hit walls.
+func (c *collider) step(state *GameState) contacts {
+	ids := make([]string, 0, len(state.Players))
+	for id := range state.Players {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	cars := make([]*CarState, len(ids))
+	boxes := make([]obb, len(ids))
+	grid := make(map[cell][]int)
+	for i, id := range ids {
+		cars[i] = state.Players[id]
+		boxes[i] = carBox(cars[i])
+		lo, hi := boxes[i].cells()
+		for x := lo.x; x <= hi.x; x++ {
+			for y := lo.y; y <= hi.y; y++ {
+				grid[cell{x, y}] = append(grid[cell{x, y}], i)
+			}
+		}
+	}
+
+	var n contacts
+	if !state.Ghosts {
+		for _, pair := range candidatePairs(grid, len(cars)) {
+			a, b := pair[0], pair[1]
+			normal, depth, ok := boxes[a].overlap(boxes[b])
+			if !ok {
+				continue
+			}
+			n.cars++
+			hitCars(cars[a], cars[b], normal, depth)
+			boxes[a], boxes[b] = carBox(cars[a]), carBox(cars[b])
+		}
+	}
+
+	for i, car := range cars {
+		for _, w := range c.wallsNear(boxes[i]) {
+			normal, depth, ok := boxes[i].overlapWall(c.walls[w])
+			if !ok {
+				continue
+			}
+			n.walls++
+			hitWall(car, normal, depth)
+			boxes[i] = carBox(car)
+		}
+		car.Speed = math.Max(-car.MaxSpeed, math.Min(car.MaxSpeed, car.Speed))
+	}
+	return n
+}
+
+// candidatePairs lists every pair of cars sharing a cell once, lowest indexes first
+func candidatePairs(grid map[cell][]int, n int) [][2]int {
+	seen := make(map[int]bool)
+	var pairs [][2]int
+	for _, in := range grid {
+		for i := 0; i < len(in); i++ {
+			for j := i + 1; j < len(in); j++ {
+				a, b := min(in[i], in[j]), max(in[i], in[j])
+				if !seen[a*n+b] {
+					seen[a*n+b] = true
+					pairs = append(pairs, [2]int{a, b})
+				}
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	return pairs
+}
+
+// wallsNear lists the walls passing through the cells the box covers, in order
+func (c *collider) wallsNear(box obb) []int {
+	if len(c.walls) == 0 {
+		return nil
+	}
+	seen := make(map[int]bool)
+	var out []int
+	lo, hi := box.cells()
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			for _, w := range c.wallCells[cell{x, y}] {
+				if !seen[w] {
+					seen[w] = true
+					out = append(out, w)
+				}
+			}
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// hitCars pushes two cars out of each other along normal, which points from a to b,
+// and if they were closing on each other slows and damages both
+func hitCars(a, b *CarState, normal tracks.Point, depth float64) {
+	a.X, a.Y = a.X-normal.X*depth/2, a.Y-normal.Y*depth/2
+	b.X, b.Y = b.X+normal.X*depth/2, b.Y+normal.Y*depth/2
+
+	va, vb := velocity(a), velocity(b)
+	closing := (va.X-vb.X)*normal.X + (va.Y-vb.Y)*normal.Y
+	if closing <= 0 {
+		return
+	}
+	for _, car := range []*CarState{a, b} {
+		car.Speed *= collisionSpeedKeep
+		damage(car, closing)
+	}
+}
+
+// hitWall pushes a car back onto the track along normal, which points away from
+// the wall, and if it was driving into the wall slows and damages it
+func hitWall(car *CarState, normal tracks.Point, depth float64) {
+	car.X, car.Y = car.X+normal.X*depth, car.Y+normal.Y*depth
+
+	v := velocity(car)
+	closing := -(v.X*normal.X + v.Y*normal.Y)
+	if closing <= 0 {
+		return
+	}
+	car.Speed *= collisionSpeedKeep
+	damage(car, closing)
+}
+
+func damage(car *CarState, impact float64) {
+	car.Damage = math.Min(damageLimit, car.Damage+impact*damagePerImpact)
+	if car.Damage >= damageLimit && !car.Damaged {
+		car.Damaged = true
+		car.MaxSpeed *= damagedSpeedFactor
+	}
+}
+
+func velocity(car *CarState) tracks.Point {
+	return tracks.Point{X: math.Cos(car.Angle) * car.Speed, Y: math.Sin(car.Angle) * car.Speed}
+}
+
+// obb is a car's oriented bounding box. The first axis points where the car is
+// heading, along its Height; the second across it, along its Width.
+type obb struct {
+	centre tracks.Point
+	axes   [2]tracks.Point
+	half   [2]float64
+}
+
+func carBox(car *CarState) obb {
+	cos, sin := math.Cos(car.Angle), math.Sin(car.Angle)
+	return obb{
+		centre: tracks.Point{X: car.X, Y: car.Y},
+		axes:   [2]tracks.Point{{X: cos, Y: sin}, {X: -sin, Y: cos}},
+		half:   [2]float64{car.Height / 2, car.Width / 2},
+	}
+}
+
+// project returns the interval the box covers along a unit axis
+func (b obb) project(axis tracks.Point) (float64, float64) {
+	c := dot(b.centre, axis)
+	r := b.half[0]*math.Abs(dot(b.axes[0], axis)) + b.half[1]*math.Abs(dot(b.axes[1], axis))
+	return c - r, c + r
+}
+
+// cells returns the lowest and highest grid cells the box's bounds touch
+func (b obb) cells() (cell, cell) {
+	rx := b.half[0]*math.Abs(b.axes[0].X) + b.half[1]*math.Abs(b.axes[1].X)
+	ry := b.half[0]*math.Abs(b.axes[0].Y) + b.half[1]*math.Abs(b.axes[1].Y)
+	return cellOf(b.centre.X-rx, b.centre.Y-ry), cellOf(b.centre.X+rx, b.centre.Y+ry)
+}
+
+// overlap tests two boxes on the separating axes, their four edge normals. When
+// they overlap it returns the axis of least penetration, pointing from b towards
+// o, and how far they overlap along it.
+func (b obb) overlap(o obb) (tracks.Point, float64, bool) {
+	axes := []tracks.Point{b.axes[0], b.axes[1], o.axes[0], o.axes[1]}
+	normal, depth, ok := leastPenetration(axes, b.project, o.project)
+	if !ok {
+		return normal, 0, false
+	}
+	if dot(tracks.Point{X: o.centre.X - b.centre.X, Y: o.centre.Y - b.centre.Y}, normal) < 0 {
+		normal = tracks.Point{X: -normal.X, Y: -normal.Y}
+	}
+	return normal, depth, true
+}
+
+// overlapWall tests the box against a wall segment, on the box's axes and the
+// wall's normal. The normal returned points from the wall towards the box.
+func (b obb) overlapWall(wall tracks.Segment) (tracks.Point, float64, bool) {
+	dx, dy := wall.B.X-wall.A.X, wall.B.Y-wall.A.Y
+	l := math.Hypot(dx, dy)
+	if l == 0 {
+		return tracks.Point{}, 0, false
+	}
+	wallNormal := tracks.Point{X: -dy / l, Y: dx / l}
+	projectWall := func(axis tracks.Point) (float64, float64) {
+		a, c := dot(wall.A, axis), dot(wall.B, axis)
+		return math.Min(a, c), math.Max(a, c)
+	}
+
+	normal, depth, ok := leastPenetration([]tracks.Point{b.axes[0], b.axes[1], wallNormal}, projectWall, b.project)
+	if !ok {
+		return normal, 0, false
+	}
+	closest := wall.Closest(b.centre)
+	if dot(tracks.Point{X: b.centre.X - closest.X, Y: b.centre.Y - closest.Y}, normal) < 0 {
+		normal = tracks.Point{X: -normal.X, Y: -normal.Y}
+	}
+	return normal, depth, true
+}
+
+// leastPenetration projects two shapes on every axis. They overlap unless one axis
+// separates them, and then the axis they overlap least on resolves them.
+func leastPenetration(axes []tracks.Point, a, b func(tracks.Point) (float64, float64)) (tracks.Point, float64, bool) {
+	var normal tracks.Point
+	depth := math.Inf(1)
+	for _, axis := range axes {
+		aMin, aMax := a(axis)
+		bMin, bMax := b(axis)
+		o := math.Min(aMax-bMin, bMax-aMin) // how far either has to move to clear the other
+		if o <= contactSlop {
+			return normal, 0, false
+		}
+		if o < depth {
+			normal, depth = axis, o
+		}
+	}
+	return normal, depth, true
+}
+
+func dot(a, b tracks.Point) float64 {
+	return a.X*b.X + a.Y*b.Y
+}
@@ -0,0 +1,327 @@
+package socket
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+)
+
+// testCar is a default car placed at x, y heading along angle
+func testCar(x, y, angle, speed float64) *CarState {
+	car := newCarState()
+	car.X, car.Y, car.Angle, car.Speed = x, y, angle, speed
+	return car
+}
+
+// testArena is a closed track boundary with its right-hand wall at x = 30
+func testArena() *tracks.Track {
+	return &tracks.Track{Left: []tracks.Point{{X: -100, Y: -100}, {X: 30, Y: -100}, {X: 30, Y: 100}, {X: -100, Y: 100}}}
+}
+
+func TestColliderStep(t *testing.T) {
+	diag := math.Sqrt(0.5) // each component of a unit vector at 45 degrees
+
+	tests := []struct {
+		name   string
+		track  *tracks.Track
+		ghosts bool
+		cars   map[string]*CarState
+		want   map[string]CarState // X, Y, Speed, Damage, Damaged and MaxSpeed are compared
+		hits   contacts
+	}{
+		{
+			name: "cars overlapping head to tail are pushed apart and damaged",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, 0, 5),
+				"b": testCar(30, 0, 0, 0),
+			},
+			want: map[string]CarState{
+				"a": {X: -5, Y: 0, Speed: 2.5, Damage: 20, MaxSpeed: 10},
+				"b": {X: 35, Y: 0, Speed: 0, Damage: 20, MaxSpeed: 10},
+			},
+			hits: contacts{cars: 1},
+		},
+		{
+			name: "cars moving apart are pushed out without damage",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, math.Pi, 5),
+				"b": testCar(30, 0, 0, 5),
+			},
+			want: map[string]CarState{
+				"a": {X: -5, Y: 0, Speed: 5, MaxSpeed: 10},
+				"b": {X: 35, Y: 0, Speed: 5, MaxSpeed: 10},
+			},
+			hits: contacts{cars: 1},
+		},
+		{
+			name: "cars exactly touching don't collide",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, 0, 5),
+				"b": testCar(40, 0, 0, 0),
+			},
+			want: map[string]CarState{
+				"a": {X: 0, Y: 0, Speed: 5, MaxSpeed: 10},
+				"b": {X: 40, Y: 0, Speed: 0, MaxSpeed: 10},
+			},
+		},
+		{
+			name: "rotated boxes that touch are pushed apart along their heading",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, math.Pi/4, 0),
+				"b": testCar(35*diag, 35*diag, math.Pi/4, 0),
+			},
+			want: map[string]CarState{
+				"a": {X: -2.5 * diag, Y: -2.5 * diag, MaxSpeed: 10},
+				"b": {X: 37.5 * diag, Y: 37.5 * diag, MaxSpeed: 10},
+			},
+			hits: contacts{cars: 1},
+		},
+		{
+			name: "rotated boxes whose bounds overlap but that miss",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, math.Pi/4, 5),
+				"b": testCar(41*diag, 41*diag, math.Pi/4, 0),
+			},
+			want: map[string]CarState{
+				"a": {X: 0, Y: 0, Speed: 5, MaxSpeed: 10},
+				"b": {X: 41 * diag, Y: 41 * diag, Speed: 0, MaxSpeed: 10},
+			},
+		},
+		{
+			name: "rotated box clipping an axis aligned one at the corner",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, 0, 0),
+				"b": testCar(30, 0, math.Pi/4, 0),
+			},
+			want: map[string]CarState{
+				// the least overlap is 10 deep, across b
+				"a": {X: -5 * diag, Y: 5 * diag, MaxSpeed: 10},
+				"b": {X: 30 + 5*diag, Y: -5 * diag, MaxSpeed: 10},
+			},
+			hits: contacts{cars: 1},
+		},
+		{
+			name:  "car driving into a wall is pushed back onto the track",
+			track: testArena(),
+			cars: map[string]*CarState{
+				"a": testCar(25, 0, 0, 4),
+			},
+			want: map[string]CarState{
+				"a": {X: 10, Y: 0, Speed: 2, Damage: 16, MaxSpeed: 10},
+			},
+			hits: contacts{walls: 1},
+		},
+		{
+			name:  "car clear of the walls is left alone",
+			track: testArena(),
+			cars: map[string]*CarState{
+				"a": testCar(5, 0, 0, 4),
+			},
+			want: map[string]CarState{
+				"a": {X: 5, Y: 0, Speed: 4, MaxSpeed: 10},
+			},
+		},
+		{
+			name:   "ghosts drive through each other but not through walls",
+			track:  testArena(),
+			ghosts: true,
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, 0, 5),
+				"b": testCar(25, 0, 0, 4),
+			},
+			want: map[string]CarState{
+				"a": {X: 0, Y: 0, Speed: 5, MaxSpeed: 10},
+				"b": {X: 10, Y: 0, Speed: 2, Damage: 16, MaxSpeed: 10},
+			},
+			hits: contacts{walls: 1},
+		},
+		{
+			name:  "the hit that reaches the damage limit cuts MaxSpeed",
+			track: testArena(),
+			cars: map[string]*CarState{
+				"a": func() *CarState { c := testCar(25, 0, 0, 4); c.Damage = 90; return c }(),
+			},
+			want: map[string]CarState{
+				"a": {X: 10, Y: 0, Speed: 2, Damage: damageLimit, Damaged: true, MaxSpeed: 10 * damagedSpeedFactor},
+			},
+			hits: contacts{walls: 1},
+		},
+		{
+			name: "speed is capped at MaxSpeed both ways",
+			cars: map[string]*CarState{
+				"a": testCar(0, 0, 0, 15),
+				"b": testCar(100, 0, 0, -15),
+			},
+			want: map[string]CarState{
+				"a": {X: 0, Y: 0, Speed: 10, MaxSpeed: 10},
+				"b": {X: 100, Y: 0, Speed: -10, MaxSpeed: 10},
+			},
+		},
+		{
+			name: "a damaged car is capped at its reduced MaxSpeed",
+			cars: map[string]*CarState{
+				"a": func() *CarState {
+					c := testCar(0, 0, 0, 9)
+					c.Damage, c.Damaged, c.MaxSpeed = damageLimit, true, 10*damagedSpeedFactor
+					return c
+				}(),
+			},
+			want: map[string]CarState{
+				"a": {X: 0, Y: 0, Speed: 10 * damagedSpeedFactor, Damage: damageLimit, Damaged: true, MaxSpeed: 10 * damagedSpeedFactor},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &GameState{Ghosts: tt.ghosts, Players: tt.cars}
+			hits := newCollider(tt.track).step(state)
+			if hits != tt.hits {
+				t.Errorf("contacts = %+v, want %+v", hits, tt.hits)
+			}
+			for id, want := range tt.want {
+				assertCar(t, id, state.Players[id], want)
+			}
+		})
+	}
+}
+
+func TestCollisionDamageBuildsUp(t *testing.T) {
+	c := newCollider(testArena())
+	car := testCar(25, 0, 0, 10)
+	state := &GameState{Players: map[string]*CarState{"a": car}}
+
+	// every hit closes at 10 and does 40 damage, the third one wrecks the car
+	wantDamage := []float64{40, 80, damageLimit}
+	for i, want := range wantDamage {
+		car.X, car.Speed = 25, car.MaxSpeed
+		c.step(state)
+		if car.Damage != want {
+			t.Fatalf("hit %d: damage = %v, want %v", i+1, car.Damage, want)
+		}
+		if damaged := i == len(wantDamage)-1; car.Damaged != damaged {
+			t.Fatalf("hit %d: damaged = %v, want %v", i+1, car.Damaged, damaged)
+		}
+	}
+	if want := 10 * damagedSpeedFactor; car.MaxSpeed != want {
+		t.Fatalf("MaxSpeed = %v, want %v", car.MaxSpeed, want)
+	}
+
+	// more hits don't take the car past the limit or cut its speed again
+	car.X, car.Speed = 25, car.MaxSpeed
+	c.step(state)
+	if car.Damage != damageLimit || car.MaxSpeed != 10*damagedSpeedFactor {
+		t.Fatalf("after another hit damage = %v, MaxSpeed = %v", car.Damage, car.MaxSpeed)
+	}
+}
+
+func TestCollisionDeterministic(t *testing.T) {
+	const ticks = 300
+
+	run := func() map[string]CarState {
+		state := &GameState{Players: make(map[string]*CarState)}
+		// a ring of cars all driving at the centre, so they pile into each other
+		for i := 0; i < 12; i++ {
+			angle := 2 * math.Pi * float64(i) / 12
+			state.Players[fmt.Sprintf("car-%02d", i)] = testCar(90*math.Cos(angle), 90*math.Sin(angle), angle+math.Pi, 0)
+		}
+		c := newCollider(&tracks.Track{Left: []tracks.Point{{X: -150, Y: -150}, {X: 150, Y: -150}, {X: 150, Y: 150}, {X: -150, Y: 150}}})
+
+		hits := contacts{}
+		for tick := 0; tick < ticks; tick++ {
+			for _, car := range state.Players {
+				car.Speed += car.Acceleration
+				car.X += math.Cos(car.Angle) * car.Speed
+				car.Y += math.Sin(car.Angle) * car.Speed
+			}
+			n := c.step(state)
+			hits.cars += n.cars
+			hits.walls += n.walls
+		}
+		if hits.cars == 0 {
+			t.Fatal("the cars never collided, the test proves nothing")
+		}
+
+		out := make(map[string]CarState, len(state.Players))
+		for id, car := range state.Players {
+			out[id] = *car
+		}
+		return out
+	}
+
+	want := run()
+	for i := 0; i < 5; i++ {
+		if got := run(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d ended differently after %d ticks", i+2, ticks)
+		}
+	}
+}
+
+func TestBroadPhaseMatchesBruteForce(t *testing.T) {
+	const cars = 32
+
+	for seed := int64(1); seed <= 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		boxes := make([]obb, cars)
+		grid := make(map[cell][]int)
+		for i := range boxes {
+			// packed tightly enough that plenty overlap, across cell borders and negative cells
+			car := testCar(rng.Float64()*300-150, rng.Float64()*300-150, rng.Float64()*2*math.Pi, 0)
+			boxes[i] = carBox(car)
+			lo, hi := boxes[i].cells()
+			for x := lo.x; x <= hi.x; x++ {
+				for y := lo.y; y <= hi.y; y++ {
+					grid[cell{x, y}] = append(grid[cell{x, y}], i)
+				}
+			}
+		}
+
+		broad := make(map[[2]int]bool)
+		for _, pair := range candidatePairs(grid, cars) {
+			if broad[pair] {
+				t.Fatalf("seed %d: pair %v listed twice", seed, pair)
+			}
+			if _, _, ok := boxes[pair[0]].overlap(boxes[pair[1]]); ok {
+				broad[pair] = true
+			}
+		}
+
+		brute := make(map[[2]int]bool)
+		for a := 0; a < cars; a++ {
+			for b := a + 1; b < cars; b++ {
+				if _, _, ok := boxes[a].overlap(boxes[b]); ok {
+					brute[[2]int{a, b}] = true
+				}
+			}
+		}
+
+		if len(brute) == 0 {
+			t.Fatalf("seed %d: no cars overlap, the test proves nothing", seed)
+		}
+		if !reflect.DeepEqual(broad, brute) {
+			t.Fatalf("seed %d: broad phase found %d overlapping pairs, brute force %d", seed, len(broad), len(brute))
+		}
+	}
+}
+
+func assertCar(t *testing.T, id string, got *CarState, want CarState) {
+	t.Helper()
+	const eps = 1e-9
+	near := func(a, b float64) bool { return math.Abs(a-b) < eps }
+	if !near(got.X, want.X) || !near(got.Y, want.Y) {
+		t.Errorf("%s at (%v, %v), want (%v, %v)", id, got.X, got.Y, want.X, want.Y)
+	}
+	if !near(got.Speed, want.Speed) {
+		t.Errorf("%s speed = %v, want %v", id, got.Speed, want.Speed)
+	}
+	if !near(got.Damage, want.Damage) || got.Damaged != want.Damaged {
+		t.Errorf("%s damage = %v (damaged %v), want %v (damaged %v)", id, got.Damage, got.Damaged, want.Damage, want.Damaged)
+	}
+	if !near(got.MaxSpeed, want.MaxSpeed) {
+		t.Errorf("%s MaxSpeed = %v, want %v", id, got.MaxSpeed, want.MaxSpeed)
+	}
+}
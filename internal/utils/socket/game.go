@@ -2,65 +2,344 @@ package socket
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/metrics"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+	"github.com/gorilla/websocket"
 )
 
 // GameManager manages the state of all active games
 type GameManager struct {
-	Games map[string]*Game
-	Hub   *Hub
-	mu    sync.RWMutex
+	Games   map[string]*Game
+	Hub     *Hub
+	Replays *ReplayStore       // nil disables replay recording
+	DB      databases.Database // used to look up match details such as bots, optional
+
+	// TickInterval is how often every game simulates and broadcasts
+	TickInterval time.Duration
+
+	// OnGameEnd is called after a game has stopped and its results were saved, optional
+	OnGameEnd func(matchID string)
+
+	// RulesFor returns the rules for a match's mode, optional. Without it, or for
+	// matches with no record, games run with zero GameRules.
+	RulesFor func(mode string) GameRules
+
+	// Tracks is what matches race on, optional. Without it cars start at the origin
+	// and there are no laps.
+	Tracks *tracks.Catalog
+
+	// AntiCheat checks client inputs before they are applied, nil trusts them as sent
+	AntiCheat *AntiCheatOptions
+
+	// AbandonAfter ends a game once no player has been connected for this long,
+	// counted from when it was created. 0 keeps games running until they finish.
+	AbandonAfter time.Duration
+
+	draining bool
+	ending   map[string]bool // games being ended whose match isn't marked over yet
+	creating sync.Mutex      // serialises CreateGame without holding up GetGame
+	mu       sync.RWMutex
 }
 
+var (
+	// ErrMatchNotFound is returned by CreateGame for a match that was never made
+	ErrMatchNotFound = errors.New("match not found")
+
+	// ErrMatchOver is returned by CreateGame for a match that has already ended
+	ErrMatchOver = errors.New("match is over")
+
+	// ErrGameFull is returned by AddBots when the bots wouldn't fit
+	ErrGameFull = errors.New("game is full")
+)
+
 func NewGameManager(hub *Hub) *GameManager {
 	return &GameManager{
-		Games: make(map[string]*Game),
-		Hub:   hub,
+		Games:        make(map[string]*Game),
+		Hub:          hub,
+		ending:       make(map[string]bool),
+		TickInterval: DefaultTickInterval,
 	}
 }
 
-func (gm *GameManager) CreateGame(matchID string) *Game {
-	gm.mu.Lock()
-	defer gm.mu.Unlock()
+// GetGame returns the running game for a match, if any
+func (gm *GameManager) GetGame(matchID string) (*Game, bool) {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+
+	game, ok := gm.Games[matchID]
+	return game, ok
+}
+
+// CreateGame starts the game for a live match, or returns the one already running.
+// With a DB, matches it has no record of and matches that have ended are refused.
+func (gm *GameManager) CreateGame(matchID string) (*Game, error) {
+	gm.creating.Lock()
+	defer gm.creating.Unlock()
 
-	if _, exists := gm.Games[matchID]; exists {
-		return gm.Games[matchID]
+	gm.mu.RLock()
+	game, exists := gm.Games[matchID]
+	ending := gm.ending[matchID]
+	gm.mu.RUnlock()
+	if exists {
+		return game, nil
+	}
+	if ending {
+		return nil, ErrMatchOver
 	}
 
-	game := &Game{
+	// The match record says which mode and track to set up and who plays on which team
+	var match models.Match
+	if gm.DB != nil {
+		var err error
+		match, err = gm.DB.GetMatchByID(context.Background(), matchID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrMatchNotFound
+		case err != nil:
+			return nil, err
+		case match.Status != models.MatchStatusLive:
+			return nil, ErrMatchOver
+		}
+	}
+
+	game = &Game{
 		MatchID: matchID,
 		State: GameState{
 			MatchID: matchID,
 			Players: make(map[string]*CarState),
 		},
-		Hub:       gm.Hub,
-		InputChan: make(chan PlayerInput),
-		Ctx:       context.Background(),
+		Hub:          gm.Hub,
+		TickInterval: gm.TickInterval,
+		InputChan:    make(chan PlayerInput),
+		Bots:         make(map[string]*Bot),
+		teams:        make(map[string]int),
+		kicked:       make(map[string]bool),
+		done:         make(chan struct{}),
+		log:          slog.With(slog.String("match_id", matchID)),
 	}
+	game.Ctx, game.cancel = context.WithCancel(context.Background())
+
+	if gm.RulesFor != nil && match.Mode != "" {
+		game.Rules = gm.RulesFor(match.Mode)
+	}
+	game.State.Mode = game.Rules.Mode
+	game.State.Ghosts = game.Rules.Ghosts
+	game.roster = match.Players
+	game.ranked = match.Ranked()
+	for team, players := range match.Teams {
+		for _, playerID := range players {
+			game.teams[playerID] = team
+		}
+	}
+	if gm.Tracks != nil {
+		game.setUpRace(gm.Tracks, match)
+		game.raceOver = func() {
+			gm.EndGame(context.Background(), matchID, models.MatchStatusFinished, websocket.CloseNormalClosure, "race finished")
+		}
+	}
+	var track *tracks.Track
+	if game.race != nil {
+		track = game.race.track
+	}
+	game.collider = newCollider(track)
+	if gm.AntiCheat != nil {
+		game.inspector = newInspector(*gm.AntiCheat, game.TickInterval)
+	}
+	if gm.DB != nil {
+		game.onIncident = func(incident models.CheatIncident) {
+			if err := gm.DB.CreateCheatIncident(context.Background(), incident); err != nil {
+				game.log.Error("Failed to save cheat incident", slog.String("player_id", incident.PlayerID), slog.String("error", err.Error()))
+			}
+		}
+	}
+	if limit := game.Rules.TimeLimit; limit > 0 {
+		game.State.EndsAt = time.Now().Add(limit).UnixMilli()
+		game.timeLimit = time.AfterFunc(limit, func() {
+			gm.EndGame(context.Background(), matchID, models.MatchStatusFinished, websocket.CloseNormalClosure, "time limit reached")
+		})
+	}
+
+	if gm.Replays != nil {
+		rec, err := gm.Replays.Open(matchID, game.State, game.TickInterval)
+		if err != nil {
+			game.log.Error("Failed to start replay", slog.String("error", err.Error()))
+		}
+		game.Replay = rec
+	}
+
+	// Bots the matchmaker backfilled into this match join straight away
+	for _, botID := range match.Bots {
+		game.AddBot(botID, match.BotDifficulty)
+	}
+
+	if gm.AbandonAfter > 0 {
+		game.abandonAfter = gm.AbandonAfter
+		game.abandoned = func() {
+			gm.EndGame(context.Background(), matchID, models.MatchStatusAbandoned, websocket.CloseNormalClosure, "match abandoned")
+		}
+	}
+
+	gm.mu.Lock()
+	gm.Games[matchID] = game
+	gm.mu.Unlock()
 
 	// Start game loop
 	go game.Run()
 
-	gm.Games[matchID] = game
-	log.Printf("Game created for match %s", matchID)
-	return game
+	game.log.Info("Game created")
+	return game, nil
+}
+
+// MatchIDs lists the matches with a running game
+func (gm *GameManager) MatchIDs() []string {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+
+	matchIDs := make([]string, 0, len(gm.Games))
+	for matchID := range gm.Games {
+		matchIDs = append(matchIDs, matchID)
+	}
+	return matchIDs
+}
+
+// Draining reports whether the manager is shutting its games down
+func (gm *GameManager) Draining() bool {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+	return gm.draining
+}
+
+// EndGame stops a game's loop, saves where every player got to, and closes
+// every connection to the match with the given close code and reason.
+func (gm *GameManager) EndGame(ctx context.Context, matchID string, status string, code int, reason string) {
+	gm.mu.Lock()
+	game, ok := gm.Games[matchID]
+	delete(gm.Games, matchID)
+	if ok {
+		gm.ending[matchID] = true
+	}
+	gm.mu.Unlock()
+	if !ok {
+		return
+	}
+	defer func() {
+		// the match is on record as over now, or never will be
+		gm.mu.Lock()
+		delete(gm.ending, matchID)
+		gm.mu.Unlock()
+	}()
+
+	if game.timeLimit != nil {
+		game.timeLimit.Stop()
+	}
+	game.cancel()
+	<-game.done
+
+	if gm.DB != nil {
+		results := game.Results()
+		if err := gm.DB.EndMatch(ctx, matchID, status, results); err != nil {
+			game.log.Error("Failed to save match results", slog.String("error", err.Error()))
+		}
+	}
+
+	gm.Hub.CloseRoom(matchID, code, reason)
+
+	if gm.OnGameEnd != nil {
+		gm.OnGameEnd(matchID)
+	}
+	game.log.Info("Game ended", slog.String("status", status), slog.String("reason", reason))
+}
+
+// Drain lets running games play on until their players have left, then ends
+// whatever is still running once ctx expires. Results of games cut short are
+// saved as partial.
+func (gm *GameManager) Drain(ctx context.Context) {
+	gm.mu.Lock()
+	gm.draining = true
+	gm.mu.Unlock()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		matchIDs := gm.MatchIDs()
+		if len(matchIDs) == 0 {
+			return
+		}
+
+		for _, matchID := range matchIDs {
+			if gm.Hub.PlayerCount(matchID) == 0 {
+				gm.EndGame(context.Background(), matchID, models.MatchStatusAbandoned, websocket.CloseNormalClosure, "match abandoned")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, matchID := range matchIDs {
+				gm.EndGame(context.Background(), matchID, models.MatchStatusAborted, websocket.CloseServiceRestart, "server shutting down")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // Game represents a single running match
 type Game struct {
-	MatchID   string
-	State     GameState
-	Hub       *Hub
-	InputChan chan PlayerInput
-	Ctx       context.Context
-	mu        sync.RWMutex
+	MatchID      string
+	State        GameState
+	Hub          *Hub
+	TickInterval time.Duration
+	InputChan    chan PlayerInput
+	Ctx          context.Context
+	Replay       *ReplayRecorder
+	Bots         map[string]*Bot
+	Rules        GameRules
+	teams        map[string]int // team of each participant the match was made with
+	timeLimit    *time.Timer    // ends the game when the mode's time limit is up
+	race         *race          // nil when the game has no track
+	raceOver     func()         // ends the game once every car has finished
+	abandoned    func()         // ends the game once nobody has been connected for abandonAfter
+	abandonAfter time.Duration  // 0 when games are never abandoned
+	collider     *collider      // resolves crashes, against the track's walls when there is one
+	inspector    *inspector     // checks client inputs, nil when they are trusted
+	onIncident   func(models.CheatIncident)
+	kicked       map[string]bool // players removed for cheating, they can't rejoin
+	roster       []string        // everyone the match was made with, connected or not
+	ranked       bool            // results count towards ratings
+	addedBots    int             // bots added through AddBots, numbers their IDs
+	cancel       context.CancelFunc
+	done         chan struct{} // closed when Run returns
+	log          *slog.Logger
+	mu           sync.RWMutex
+}
+
+// GameRules is how a match is played, set up from its mode
+type GameRules struct {
+	Mode      string
+	TimeLimit time.Duration // 0 for no limit
+	Ghosts    bool          // cars drive through each other
+	Track     string        // raced unless the match picked one, "" for the default track
+	Laps      int           // 0 for the track's lap count
 }
 
 type GameState struct {
 	MatchID string               `json:"match_id"`
+	Mode    string               `json:"mode,omitempty"`
+	Track   string               `json:"track,omitempty"`
+	Laps    int                  `json:"laps,omitempty"`
+	Ghosts  bool                 `json:"ghosts,omitempty"`
+	EndsAt  int64                `json:"ends_at,omitempty"` // unix millis the time limit is up at
 	Tick    int64                `json:"tick"`
 	Players map[string]*CarState `json:"players"`
 }
@@ -75,7 +354,19 @@ type CarState struct {
 	MaxSpeed     float64 `json:"maxSpeed"`
 	Friction     float64 `json:"friction"`
 	Angle        float64 `json:"angle"`
+	Damage       float64 `json:"damage"` // from collisions, the car is Damaged at 100
 	Damaged      bool    `json:"damaged"`
+	Bot          bool    `json:"bot,omitempty"`
+	Team         int     `json:"team"`
+
+	// Race progress, kept by the server. Times are in milliseconds.
+	Lap        int     `json:"lap,omitempty"`        // lap being driven, from 1
+	Checkpoint int     `json:"checkpoint,omitempty"` // next checkpoint to cross, 0 being the finish line
+	Position   int     `json:"position,omitempty"`
+	LapTimes   []int64 `json:"lap_times,omitempty"`
+	BestLap    int64   `json:"best_lap,omitempty"`
+	Finished   bool    `json:"finished,omitempty"`
+	RaceTime   int64   `json:"race_time,omitempty"` // from the start to crossing the line on the last lap
 }
 
 type PlayerInput struct {
@@ -84,19 +375,45 @@ type PlayerInput struct {
 	Payload  CarState `json:"payload"`
 }
 
+// DefaultTickInterval is the simulation rate used when none is configured, 20Hz
+const DefaultTickInterval = 50 * time.Millisecond
+
 func (g *Game) Run() {
-	ticker := time.NewTicker(50 * time.Millisecond)
+	defer close(g.done)
+
+	ticker := time.NewTicker(g.TickInterval)
 	defer ticker.Stop()
 
+	tickDuration := metrics.TickDuration.WithLabelValues(g.MatchID)
+	tickOverruns := metrics.TickOverruns.WithLabelValues(g.MatchID)
+	carCollisions := metrics.Collisions.WithLabelValues("car")
+	wallCollisions := metrics.Collisions.WithLabelValues("wall")
+	defer metrics.TickDuration.DeleteLabelValues(g.MatchID)
+	defer metrics.TickOverruns.DeleteLabelValues(g.MatchID)
+
+	// a nil channel never fires, games that can't be abandoned never check
+	var checkAbandoned <-chan time.Time
+	lastConnected := time.Now()
+	if g.abandoned != nil {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		checkAbandoned = t.C
+	}
+
 	for {
 		select {
 		case <-g.Ctx.Done():
+			g.mu.RLock()
+			g.Replay.Close(g.State.Tick)
+			g.log.Info("Game stopped", slog.Int64("tick", g.State.Tick))
+			g.mu.RUnlock()
 			return
 		case <-ticker.C:
+			tickStart := time.Now()
 			g.mu.Lock()
-			g.State.Tick++
-			// Here: Update Physics using Speed, Acceleration, Angle etc.
-			// loop through players and update positions based on speed/angle if server authoritative.
+			hits := g.step()
+			carCollisions.Add(float64(hits.cars))
+			wallCollisions.Add(float64(hits.walls))
 
 			// Broadcast state
 			stateBytes, _ := json.Marshal(g.State)
@@ -105,28 +422,279 @@ func (g *Game) Run() {
 			// Send to Hub to broadcast to specific room
 			g.Hub.broadcast <- Message{MatchID: g.MatchID, Payload: stateBytes}
 
+			elapsed := time.Since(tickStart)
+			tickDuration.Observe(elapsed.Seconds())
+			if elapsed > g.TickInterval {
+				tickOverruns.Inc()
+				g.log.Debug("Tick overran", slog.Duration("elapsed", elapsed))
+			}
+
 		case input := <-g.InputChan:
 			g.mu.Lock()
-			if player, ok := g.State.Players[input.PlayerID]; ok {
-				// Update player state from input payload (Client Authoritative for now)
-				player.X = input.Payload.X
-				player.Y = input.Payload.Y
-				player.Speed = input.Payload.Speed
-				player.Angle = input.Payload.Angle
-				// We can update other fields if sent
-			}
+			g.handleInput(input)
 			g.mu.Unlock()
+
+		case <-checkAbandoned:
+			if g.Hub.PlayerCount(g.MatchID) > 0 {
+				lastConnected = time.Now()
+			} else if time.Since(lastConnected) >= g.abandonAfter {
+				checkAbandoned = nil
+				go g.abandoned() // ending the game waits for this loop to return
+			}
+		}
+	}
+}
+
+// step advances the game by one tick and records it. Must hold g.mu.
+func (g *Game) step() contacts {
+	g.State.Tick++
+	g.driveBots()
+	// Here: Update Physics using Speed, Acceleration, Angle etc.
+	// loop through players and update positions based on speed/angle if server authoritative.
+	hits := g.collider.step(&g.State)
+	if g.race != nil && g.race.step(&g.State, g.TickInterval) {
+		go g.raceOver() // ending the game waits for this loop to return
+	}
+	g.Replay.RecordTick(g.State)
+	return hits
+}
+
+// nextTick is the tick that changes made between ticks take effect in, which is
+// what they are recorded with so replays apply them in the same order
+func (g *Game) nextTick() int64 {
+	return g.State.Tick + 1
+}
+
+// handleInput checks a client's input and applies what is left of it. Only what
+// was applied is recorded, so replays don't need the checks. Must hold g.mu.
+func (g *Game) handleInput(input PlayerInput) {
+	car, ok := g.State.Players[input.PlayerID]
+	if !ok {
+		g.log.Debug("Dropped input for player not in game", slog.String("player_id", input.PlayerID))
+		return
+	}
+
+	if g.inspector != nil {
+		v := g.inspector.check(g.State.Tick, car, input)
+		for _, kind := range v.violations {
+			metrics.CheatViolations.WithLabelValues(kind).Inc()
+		}
+		if len(v.violations) > 0 {
+			g.log.Debug("Corrected suspicious input", slog.String("player_id", input.PlayerID),
+				slog.Bool("dropped", v.drop), slog.String("detail", v.detail), slog.Float64("score", v.score))
+		}
+		if v.escalate != "" {
+			g.escalate(input.PlayerID, v)
+		}
+		if v.drop {
+			return
+		}
+		input = v.input
+	}
+
+	applyInput(&g.State, input)
+	g.Replay.RecordInput(g.nextTick(), input)
+}
+
+// escalate saves an incident for a player whose suspicion crossed a threshold and
+// removes them from the game when it was the kick threshold. Must hold g.mu.
+func (g *Game) escalate(playerID string, v verdict) {
+	incident := models.CheatIncident{
+		MatchID:    g.MatchID,
+		PlayerID:   playerID,
+		Tick:       g.State.Tick,
+		Action:     v.escalate,
+		Score:      v.score,
+		Violations: v.counts,
+		Detail:     v.detail,
+	}
+	metrics.CheatEscalations.WithLabelValues(v.escalate).Inc()
+	if g.onIncident != nil {
+		go g.onIncident(incident)
+	}
+
+	log := g.log.With(slog.String("player_id", playerID), slog.Float64("score", v.score), slog.Any("violations", v.counts))
+	if v.escalate != models.IncidentKicked {
+		log.Warn("Player flagged for suspicious inputs")
+		return
+	}
+	log.Warn("Player kicked for cheating")
+	delete(g.State.Players, playerID)
+	g.kicked[playerID] = true
+	g.Replay.RecordLeave(g.nextTick(), playerID)
+	go g.Hub.ClosePlayer(g.MatchID, playerID, websocket.ClosePolicyViolation, "kicked for cheating")
+}
+
+// Kicked reports whether a player was removed from the game for cheating
+func (g *Game) Kicked(playerID string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.kicked[playerID]
+}
+
+// driveBots feeds each bot's input through the same path as a client's. Must hold g.mu.
+func (g *Game) driveBots() {
+	for id, bot := range g.Bots {
+		car, ok := g.State.Players[id]
+		if !ok {
+			continue
 		}
+		input := bot.NextInput(*car)
+		if applyInput(&g.State, input) {
+			g.Replay.RecordInput(g.State.Tick, input)
+		}
+	}
+}
+
+// applyInput updates a car from a player's input and reports whether it was accepted.
+// Shared with replay playback so recorded inputs produce the same state.
+func applyInput(state *GameState, input PlayerInput) bool {
+	player, ok := state.Players[input.PlayerID]
+	if !ok {
+		return false
 	}
+	// Update player state from input payload (Client Authoritative for now)
+	player.X = input.Payload.X
+	player.Y = input.Payload.Y
+	player.Speed = input.Payload.Speed
+	player.Angle = input.Payload.Angle
+	// We can update other fields if sent
+	return true
 }
 
-// Helper to add player
+// Results snapshots where each participant is in the game, and in the race when
+// there is one
+func (g *Game) Results() []models.PlayerResult {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	results := make([]models.PlayerResult, 0, len(g.State.Players))
+	for playerID, car := range g.State.Players {
+		results = append(results, models.PlayerResult{
+			MatchID:  g.MatchID,
+			PlayerID: playerID,
+			Bot:      car.Bot,
+			Tick:     g.State.Tick,
+			X:        car.X,
+			Y:        car.Y,
+			Damaged:  car.Damaged,
+			Ranked:   g.ranked,
+			Position: car.Position,
+			LapTimes: append([]int64(nil), car.LapTimes...),
+			BestLap:  car.BestLap,
+			Finished: car.Finished,
+			RaceTime: car.RaceTime,
+		})
+	}
+	return results
+}
+
+// Helper to add player. A player reconnecting gets their car back where they left it,
+// a player kicked for cheating gets nothing.
 func (g *Game) AddPlayer(playerID string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	if _, ok := g.State.Players[playerID]; ok || g.kicked[playerID] {
+		return
+	}
+	car := newCarState()
+	car.Team = g.teams[playerID]
+	g.placeOnGrid(car)
+	g.State.Players[playerID] = car
+	g.Replay.RecordJoin(g.nextTick(), playerID, false, car.Team)
+}
+
+// AddBot adds a server-driven participant to the game
+func (g *Game) AddBot(playerID, difficulty string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.addBot(playerID, difficulty)
+}
+
+// AddBots adds count bots on top of the match's players, or none if the game would
+// then hold more than limit cars, counting players who haven't connected yet.
+// Returns the IDs the bots were given.
+func (g *Game) AddBots(count int, difficulty string, limit int) ([]string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	players := len(g.State.Players)
+	for _, id := range g.roster {
+		if _, ok := g.State.Players[id]; !ok {
+			players++
+		}
+	}
+	if players+count > limit {
+		return nil, ErrGameFull
+	}
+
+	ids := make([]string, 0, count)
+	for len(ids) < count {
+		g.addedBots++
+		id := fmt.Sprintf("bot-%s-%d", g.MatchID, g.addedBots)
+		if _, taken := g.State.Players[id]; taken {
+			continue
+		}
+		g.addBot(id, difficulty)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// addBot puts a bot's car on the grid. Must hold g.mu.
+func (g *Game) addBot(playerID, difficulty string) {
+	car := newCarState()
+	car.Bot = true
+	car.Team = g.teams[playerID]
+	g.placeOnGrid(car)
+	g.State.Players[playerID] = car
+
+	var waypoints []tracks.Point
+	if g.race != nil {
+		waypoints = g.race.track.Waypoints()
+	}
+	g.Bots[playerID] = NewBot(playerID, difficulty, waypoints)
+	g.Replay.RecordJoin(g.nextTick(), playerID, true, car.Team)
+}
+
+// setUpRace picks the track and lap count: the match's own, else its mode's, else
+// the default track and its lap count
+func (g *Game) setUpRace(catalog *tracks.Catalog, match models.Match) {
+	name := match.Track
+	if name == "" {
+		name = g.Rules.Track
+	}
+	track, ok := catalog.Get(name)
+	if !ok {
+		g.log.Warn("Unknown track, racing the default", slog.String("track", name), slog.String("default", catalog.Default))
+		track, _ = catalog.Get("")
+	}
+
+	laps := match.Laps
+	if laps == 0 {
+		laps = g.Rules.Laps
+	}
+	if laps == 0 {
+		laps = track.Laps
+	}
+
+	g.race = newRace(track, laps, g.State.Tick)
+	g.State.Track = track.Name
+	g.State.Laps = laps
+}
+
+// placeOnGrid puts a new car in the next free grid slot, in the order cars join.
+// Must hold g.mu.
+func (g *Game) placeOnGrid(car *CarState) {
+	if g.race != nil {
+		g.race.place(car, len(g.State.Players))
+	}
+}
+
+func newCarState() *CarState {
 	// Initialize default car state
-	g.State.Players[playerID] = &CarState{
+	return &CarState{
 		X:            0,
 		Y:            0,
 		Width:        20, // Example defaults
@@ -1,22 +1,99 @@
 package socket
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/metrics"
 	"github.com/gorilla/websocket"
 )
 
-// Client represents a connected player
+// Client represents a connected player or spectator
 type Client struct {
-	Hub      *Hub
-	Game     *Game // Link to game to send inputs
-	MatchID  string
-	PlayerID string
-	Conn     *websocket.Conn
-	Send     chan []byte
+	Hub       *Hub
+	Game      *Game // Link to game to send inputs, nil for spectators
+	MatchID   string
+	PlayerID  string
+	Spectator bool // read-only connection, inputs are discarded
+	Conn      *websocket.Conn
+	Send      chan []byte
+
+	log *slog.Logger
+
+	// closeFrame is sent instead of an empty close message when the hub ends the connection
+	closeFrame []byte
+
+	// maxSpectators is how many spectators the hub lets into the room, counting
+	// this one, when it registers. 0 for no limit.
+	maxSpectators int
+
+	// closed, when set, is closed once the connection's read side ends
+	closed chan struct{}
+
+	// delayed buffers broadcasts for spectators watching with a delay. When set the
+	// hub writes here instead of Send and delayPump owns Send.
+	delayed chan delayedMessage
+}
+
+type delayedMessage struct {
+	at      time.Time
+	payload []byte
+}
+
+const spectatorLimitReason = "spectator limit reached"
+
+// SpectatorOptions controls read-only connections to a match
+type SpectatorOptions struct {
+	MaxPerMatch int           // 0 disables spectating
+	Delay       time.Duration // how far behind the live state spectators are kept, to prevent ghosting
+}
+
+// deliver queues a payload for the client without blocking the hub
+func (c *Client) deliver(payload []byte) bool {
+	if c.delayed != nil {
+		select {
+		case c.delayed <- delayedMessage{at: time.Now(), payload: payload}:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case c.Send <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeOutbox closes whichever channel the hub writes into for this client
+func (c *Client) closeOutbox() {
+	if c.delayed != nil {
+		close(c.delayed)
+		return
+	}
+	close(c.Send)
+}
+
+// delayPump holds each broadcast back until delay has passed, then forwards it to Send
+func (c *Client) delayPump(delay time.Duration) {
+	defer close(c.Send)
+	for msg := range c.delayed {
+		if wait := time.Until(msg.at.Add(delay)); wait > 0 {
+			time.Sleep(wait)
+		}
+		select {
+		case c.Send <- msg.payload:
+		default:
+			// writer is behind, drop the frame rather than falling further back
+		}
+	}
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the match rooms
@@ -24,6 +101,9 @@ type Hub struct {
 	// Registered clients, grouped by matchID
 	matches map[string]map[*Client]bool
 
+	// Registered spectators, grouped by matchID
+	spectators map[string]map[*Client]bool
+
 	// Register requests from the clients.
 	register chan *Client
 
@@ -33,20 +113,39 @@ type Hub struct {
 	// Inbound messages from the clients.
 	broadcast chan Message
 
+	// Liveness probes, answered by Run.
+	ping chan chan struct{}
+
+	// Requests to disconnect everyone in a match, or one player.
+	closeRoom chan closeRequest
+
 	mu sync.RWMutex
 }
 
+type closeRequest struct {
+	MatchID  string
+	PlayerID string // only this player's connections, everyone when empty
+	Frame    []byte
+}
+
 type Message struct {
 	MatchID string
 	Payload []byte
 }
 
+// broadcastBuffer lets game loops hand off snapshots without waiting on the hub.
+// Its fill level is exported as the hub backlog.
+const broadcastBuffer = 1024
+
 func NewHub() *Hub {
 	return &Hub{
 		matches:    make(map[string]map[*Client]bool),
+		spectators: make(map[string]map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
-		broadcast:  make(chan Message),
+		broadcast:  make(chan Message, broadcastBuffer),
+		ping:       make(chan chan struct{}),
+		closeRoom:  make(chan closeRequest),
 	}
 }
 
@@ -55,52 +154,175 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			if _, ok := h.matches[client.MatchID]; !ok {
-				h.matches[client.MatchID] = make(map[*Client]bool)
+			rooms := h.roomsFor(client)
+			if client.maxSpectators > 0 && len(rooms[client.MatchID]) >= client.maxSpectators {
+				// the room filled up while this spectator was connecting
+				client.closeFrame = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, spectatorLimitReason)
+				client.closeOutbox()
+				h.mu.Unlock()
+				continue
+			}
+			if _, ok := rooms[client.MatchID]; !ok {
+				rooms[client.MatchID] = make(map[*Client]bool)
 			}
-			h.matches[client.MatchID][client] = true
+			rooms[client.MatchID][client] = true
+			h.observeRoom(client.MatchID, client.Spectator)
 			h.mu.Unlock()
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if clients, ok := h.matches[client.MatchID]; ok {
+			rooms := h.roomsFor(client)
+			if clients, ok := rooms[client.MatchID]; ok {
 				if _, ok := clients[client]; ok {
 					delete(clients, client)
-					close(client.Send)
+					client.closeOutbox()
 					if len(clients) == 0 {
-						delete(h.matches, client.MatchID)
+						delete(rooms, client.MatchID)
 					}
 				}
 			}
+			h.observeRoom(client.MatchID, client.Spectator)
+			h.mu.Unlock()
+
+		case reply := <-h.ping:
+			close(reply)
+
+		case req := <-h.closeRoom:
+			h.mu.Lock()
+			for _, spectators := range []bool{false, true} {
+				rooms := h.matches
+				if spectators {
+					rooms = h.spectators
+				}
+				for client := range rooms[req.MatchID] {
+					if req.PlayerID != "" && client.PlayerID != req.PlayerID {
+						continue
+					}
+					client.closeFrame = req.Frame
+					client.closeOutbox()
+					delete(rooms[req.MatchID], client)
+				}
+				if len(rooms[req.MatchID]) == 0 {
+					delete(rooms, req.MatchID)
+				}
+				h.observeRoom(req.MatchID, spectators)
+			}
 			h.mu.Unlock()
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
-			if clients, ok := h.matches[message.MatchID]; ok {
-				for client := range clients {
-					select {
-					case client.Send <- message.Payload:
-					default:
-						close(client.Send)
-						delete(clients, client)
+			metrics.BroadcastBacklog.Set(float64(len(h.broadcast)))
+
+			// write lock, slow consumers are removed from the room
+			h.mu.Lock()
+			for _, spectators := range []bool{false, true} {
+				rooms := h.matches
+				if spectators {
+					rooms = h.spectators
+				}
+				if clients, ok := rooms[message.MatchID]; ok {
+					for client := range clients {
+						if !client.deliver(message.Payload) {
+							client.closeOutbox()
+							delete(clients, client)
+							metrics.SlowConsumerDisconnects.Inc()
+						}
 					}
+					h.observeRoom(message.MatchID, spectators)
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
 		}
 	}
 }
 
+// observeRoom updates the connected clients gauge for a room. Must hold h.mu.
+func (h *Hub) observeRoom(matchID string, spectators bool) {
+	kind, rooms := "player", h.matches
+	if spectators {
+		kind, rooms = "spectator", h.spectators
+	}
+
+	if n := len(rooms[matchID]); n > 0 {
+		metrics.ConnectedClients.WithLabelValues(matchID, kind).Set(float64(n))
+	} else {
+		metrics.ConnectedClients.DeleteLabelValues(matchID, kind)
+	}
+}
+
+func (h *Hub) roomsFor(client *Client) map[string]map[*Client]bool {
+	if client.Spectator {
+		return h.spectators
+	}
+	return h.matches
+}
+
+// Ping checks the Run loop is still picking up work
+func (h *Hub) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.ping <- reply:
+	case <-ctx.Done():
+		return errors.New("hub loop is not responding")
+	}
+	<-reply
+	return nil
+}
+
+// CloseRoom disconnects every player and spectator of a match with a close frame
+func (h *Hub) CloseRoom(matchID string, code int, reason string) {
+	h.closeRoom <- closeRequest{MatchID: matchID, Frame: websocket.FormatCloseMessage(code, reason)}
+}
+
+// ClosePlayer disconnects a player's connections to a match with a close frame
+func (h *Hub) ClosePlayer(matchID, playerID string, code int, reason string) {
+	h.closeRoom <- closeRequest{MatchID: matchID, PlayerID: playerID, Frame: websocket.FormatCloseMessage(code, reason)}
+}
+
+// PlayerCount returns the number of players connected to a match
+func (h *Hub) PlayerCount(matchID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.matches[matchID])
+}
+
+// SpectatorCount returns the number of spectators watching a match
+func (h *Hub) SpectatorCount(matchID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.spectators[matchID])
+}
+
 // serveWs handles websocket requests from the peer.
 func ServeWs(hub *Hub, gm *GameManager, w http.ResponseWriter, r *http.Request, matchID, playerID string) {
+	log := logger.FromContext(r.Context()).With(slog.String("match_id", matchID), slog.String("player_id", playerID))
+
+	// Create or Get Game, only for matches that were made and haven't ended
+	game, err := gm.CreateGame(matchID)
+	switch {
+	case errors.Is(err, ErrMatchNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrMatchOver):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		log.Error("Failed to start game", slog.String("error", err.Error()))
+		http.Error(w, "failed to start game", http.StatusInternalServerError)
+		return
+	}
+
+	// a player kicked for cheating stays out until the match is over
+	if game.Kicked(playerID) {
+		http.Error(w, "kicked from match", http.StatusForbidden)
+		return
+	}
+
 	conn, err := Upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		log.Error("Websocket upgrade failed", slog.String("error", err.Error()))
 		return
 	}
 
-	// Create or Get Game
-	game := gm.CreateGame(matchID)
 	game.AddPlayer(playerID)
 
 	client := &Client{
@@ -110,6 +332,7 @@ func ServeWs(hub *Hub, gm *GameManager, w http.ResponseWriter, r *http.Request,
 		PlayerID: playerID,
 		Conn:     conn,
 		Send:     make(chan []byte, 256),
+		log:      log,
 	}
 	client.Hub.register <- client
 
@@ -119,29 +342,82 @@ func ServeWs(hub *Hub, gm *GameManager, w http.ResponseWriter, r *http.Request,
 	go client.readPump()
 }
 
+// ServeSpectatorWs attaches a read-only connection to a running match. Spectators
+// receive state broadcasts, never get a car and cannot send inputs.
+func ServeSpectatorWs(hub *Hub, gm *GameManager, opts SpectatorOptions, w http.ResponseWriter, r *http.Request, matchID string) {
+	if _, ok := gm.GetGame(matchID); !ok {
+		http.Error(w, "match not found", http.StatusNotFound)
+		return
+	}
+	// the hub enforces the limit when the spectator registers, this spares the upgrade
+	if hub.SpectatorCount(matchID) >= opts.MaxPerMatch {
+		http.Error(w, spectatorLimitReason, http.StatusServiceUnavailable)
+		return
+	}
+
+	log := logger.FromContext(r.Context()).With(slog.String("match_id", matchID), slog.Bool("spectator", true))
+
+	conn, err := Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error("Websocket upgrade failed", slog.String("error", err.Error()))
+		return
+	}
+
+	client := &Client{
+		Hub:           hub,
+		MatchID:       matchID,
+		Spectator:     true,
+		Conn:          conn,
+		Send:          make(chan []byte, 256),
+		log:           log,
+		maxSpectators: opts.MaxPerMatch,
+	}
+	if opts.Delay > 0 {
+		// enough room to hold the whole delay window at the tick rate
+		client.delayed = make(chan delayedMessage, int(opts.Delay/gm.TickInterval)+256)
+		go client.delayPump(opts.Delay)
+	}
+	client.Hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		c.Hub.unregister <- c
 		c.Conn.Close()
+		if c.closed != nil {
+			close(c.closed)
+		}
 	}()
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				c.log.Warn("Websocket closed unexpectedly", slog.String("error", err.Error()))
 			}
 			break
 		}
+		if c.Spectator {
+			// Spectators are read-only, drop anything they send
+			continue
+		}
+
 		// Forward input to Game
 		var input PlayerInput
 		if err := json.Unmarshal(message, &input); err != nil {
-			log.Printf("error unmarshalling input: %v", err)
+			c.log.Warn("Error unmarshalling input", slog.String("error", err.Error()))
 			continue
 		}
 		// Force PlayerID to match the connection's player ID to prevents spoofing
 		input.PlayerID = c.PlayerID
 
-		c.Game.InputChan <- input
+		select {
+		case c.Game.InputChan <- input:
+		case <-c.Game.done:
+			// game is over, wait for the hub to close the connection
+		}
 	}
 }
 
@@ -154,7 +430,7 @@ func (c *Client) writePump() {
 		case message, ok := <-c.Send:
 			if !ok {
 				// The hub closed the channel.
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeFrame)
 				return
 			}
 
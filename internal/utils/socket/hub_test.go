@@ -0,0 +1,48 @@
+package socket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHubSpectatorLimit(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	// spectators that all passed the early check before any of them registered
+	clients := make([]*Client, 5)
+	for i := range clients {
+		clients[i] = &Client{Hub: hub, MatchID: "m", Spectator: true, Send: make(chan []byte, 1), maxSpectators: 2}
+		hub.register <- clients[i]
+	}
+	if err := hub.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := hub.SpectatorCount("m"); got != 2 {
+		t.Errorf("SpectatorCount = %d, want 2", got)
+	}
+	for i, c := range clients {
+		closed := false
+		select {
+		case _, ok := <-c.Send:
+			closed = !ok
+		default:
+		}
+		if i < 2 {
+			if closed {
+				t.Errorf("spectator %d was turned away from a room with space", i)
+			}
+			continue
+		}
+		if !closed {
+			t.Fatalf("spectator %d got in past the limit", i)
+		}
+		want := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, spectatorLimitReason)
+		if string(c.closeFrame) != string(want) {
+			t.Errorf("spectator %d closed with %q, want %q", i, c.closeFrame, want)
+		}
+	}
+}
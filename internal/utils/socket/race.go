@@ -0,0 +1,149 @@
+package socket
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+)
+
+// race follows every car round the track: the checkpoint it needs next, its laps
+// and their times, and its place. Crossings are found from where each car was at
+// the previous step, so a race has to be stepped every tick. Not safe for
+// concurrent use, games step it under their lock.
+type race struct {
+	track     *tracks.Track
+	laps      int
+	startTick int64
+	lapStart  map[string]int64        // tick each car's current lap started at, startTick until its first lap
+	last      map[string]tracks.Point // where each car was at the previous step
+	over      bool
+}
+
+func newRace(track *tracks.Track, laps int, startTick int64) *race {
+	return &race{
+		track:     track,
+		laps:      laps,
+		startTick: startTick,
+		lapStart:  make(map[string]int64),
+		last:      make(map[string]tracks.Point),
+	}
+}
+
+// place puts a new car on grid slot i. Grid slots are behind the start line, so
+// the first checkpoint due is the one after it.
+func (r *race) place(car *CarState, slot int) {
+	s := r.track.Slot(slot)
+	car.X, car.Y, car.Angle = s.X, s.Y, s.Angle
+	car.Lap = 1
+	car.Checkpoint = 1
+}
+
+// follow moves every car's previous position to where it is now without looking
+// for crossings, for a state that was stepped elsewhere
+func (r *race) follow(state *GameState) {
+	for id, car := range state.Players {
+		r.last[id] = tracks.Point{X: car.X, Y: car.Y}
+	}
+}
+
+// step records the checkpoints and laps every car completed since the previous
+// step and ranks the cars. Reports true once, at the step every car finished.
+func (r *race) step(state *GameState, tickInterval time.Duration) bool {
+	gates := r.track.Checkpoints
+	for id, car := range state.Players {
+		pos := tracks.Point{X: car.X, Y: car.Y}
+		prev, seen := r.last[id]
+		r.last[id] = pos
+		if !seen || car.Finished || car.Lap == 0 {
+			continue
+		}
+
+		// a fast car can pass more than one gate in a tick
+		for i := 0; i < len(gates) && gates[car.Checkpoint].Crosses(prev, pos); i++ {
+			if car.Checkpoint != 0 {
+				car.Checkpoint = (car.Checkpoint + 1) % len(gates)
+				continue
+			}
+
+			// crossed the finish line
+			lapTime := ticksToMillis(state.Tick-r.lapStartOf(id), tickInterval)
+			car.LapTimes = append(car.LapTimes, lapTime)
+			if car.BestLap == 0 || lapTime < car.BestLap {
+				car.BestLap = lapTime
+			}
+			r.lapStart[id] = state.Tick
+			if car.Lap >= r.laps {
+				car.Finished = true
+				car.RaceTime = ticksToMillis(state.Tick-r.startTick, tickInterval)
+				break
+			}
+			car.Lap++
+			car.Checkpoint = 1 % len(gates)
+		}
+	}
+
+	r.rank(state)
+
+	if r.over || len(state.Players) == 0 {
+		return false
+	}
+	for _, car := range state.Players {
+		if !car.Finished {
+			return false
+		}
+	}
+	r.over = true
+	return true
+}
+
+// rank orders the cars: finishers by race time, then everyone else by laps,
+// checkpoints and how close they are to the next one. Ties go by player ID so
+// every replay of a race ranks it the same.
+func (r *race) rank(state *GameState) {
+	gates := r.track.Checkpoints
+	ids := make([]string, 0, len(state.Players))
+	for id := range state.Players {
+		ids = append(ids, id)
+	}
+
+	passed := func(car *CarState) int {
+		return (car.Checkpoint - 1 + len(gates)) % len(gates) // gates behind the car this lap
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := state.Players[ids[i]], state.Players[ids[j]]
+		switch {
+		case a.Finished != b.Finished:
+			return a.Finished
+		case a.Finished && a.RaceTime != b.RaceTime:
+			return a.RaceTime < b.RaceTime
+		case a.Finished:
+			return ids[i] < ids[j]
+		case a.Lap != b.Lap:
+			return a.Lap > b.Lap
+		case passed(a) != passed(b):
+			return passed(a) > passed(b)
+		}
+		da := gates[a.Checkpoint].Distance(tracks.Point{X: a.X, Y: a.Y})
+		db := gates[b.Checkpoint].Distance(tracks.Point{X: b.X, Y: b.Y})
+		if da != db {
+			return da < db
+		}
+		return ids[i] < ids[j]
+	})
+
+	for i, id := range ids {
+		state.Players[id].Position = i + 1
+	}
+}
+
+func (r *race) lapStartOf(id string) int64 {
+	if tick, ok := r.lapStart[id]; ok {
+		return tick
+	}
+	return r.startTick
+}
+
+func ticksToMillis(ticks int64, tickInterval time.Duration) int64 {
+	return (time.Duration(ticks) * tickInterval).Milliseconds()
+}
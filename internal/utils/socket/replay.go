@@ -0,0 +1,398 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/databases"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/logger"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/models"
+	"github.com/gopalkalawate/multiplayer-game-backend/internal/tracks"
+	"github.com/gorilla/websocket"
+)
+
+/*
+A replay file is a stream of JSON entries, one per line, in the order the game
+applied them. Each entry carries the tick it took effect in, and a tick's keyframe
+is its state once every entry for it was applied and the tick was stepped:
+
+	header    the initial GameState and the tick interval it was recorded at
+	join      a player was added to the game
+	leave     a player was removed from the game
+	input     a PlayerInput accepted by Game.Run
+	keyframe  a full GameState snapshot, written every KeyframeEvery ticks
+	end       the game loop stopped
+*/
+const (
+	entryHeader   = "header"
+	entryJoin     = "join"
+	entryLeave    = "leave"
+	entryInput    = "input"
+	entryKeyframe = "keyframe"
+	entryEnd      = "end"
+)
+
+type replayEntry struct {
+	Type     string       `json:"type"`
+	Tick     int64        `json:"tick"`
+	PlayerID string       `json:"player_id,omitempty"`
+	Bot      bool         `json:"bot,omitempty"`
+	Team     int          `json:"team,omitempty"`
+	Input    *PlayerInput `json:"input,omitempty"`
+	State    *GameState   `json:"state,omitempty"`
+
+	TickIntervalMs int64 `json:"tick_interval_ms,omitempty"` // header only
+}
+
+// ReplayStore creates replay files on disk and indexes them in the database
+type ReplayStore struct {
+	DB            databases.Database
+	Dir           string
+	KeyframeEvery int64
+}
+
+var (
+	// ErrReplayExists is returned by Open when the match has been recorded already
+	ErrReplayExists = errors.New("replay already exists")
+
+	// ErrReplayNotLive is returned by Open for a match that isn't in progress
+	ErrReplayNotLive = errors.New("only live matches can be recorded")
+)
+
+// Open starts recording a match from its initial state. Only live matches are
+// recorded, and never over an earlier recording of the same match.
+func (rs *ReplayStore) Open(matchID string, initial GameState, tickInterval time.Duration) (*ReplayRecorder, error) {
+	match, err := rs.DB.GetMatchByID(context.Background(), matchID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && match.Status != models.MatchStatusLive) {
+		return nil, ErrReplayNotLive
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(rs.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(rs.Dir, replayFileName(matchID))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, ErrReplayExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rs.DB.CreateReplay(context.Background(), models.Replay{MatchID: matchID, Path: path}); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	rec := &ReplayRecorder{
+		file:          file,
+		w:             bufio.NewWriter(file),
+		keyframeEvery: rs.KeyframeEvery,
+	}
+	rec.write(replayEntry{
+		Type:           entryHeader,
+		Tick:           initial.Tick,
+		State:          &initial,
+		TickIntervalMs: tickInterval.Milliseconds(),
+	})
+	return rec, nil
+}
+
+// replayFileName keeps a match ID to characters that are safe in a file name. The
+// hash of the full ID keeps IDs that only differ in what was replaced apart.
+func replayFileName(matchID string) string {
+	safe := []byte(matchID)
+	for i, c := range safe {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			safe[i] = '_'
+		}
+	}
+	if len(safe) > 100 {
+		safe = safe[:100]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(matchID))
+	return fmt.Sprintf("%s-%08x.jsonl", safe, h.Sum32())
+}
+
+// ReplayRecorder appends entries to a single match's replay file.
+// All methods are safe to call on a nil recorder, which records nothing.
+type ReplayRecorder struct {
+	file          *os.File
+	w             *bufio.Writer
+	keyframeEvery int64
+	mu            sync.Mutex
+}
+
+func (r *ReplayRecorder) write(entry replayEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Error encoding replay entry", slog.String("error", err.Error()))
+		return
+	}
+	r.w.Write(b)
+	r.w.WriteByte('\n')
+}
+
+func (r *ReplayRecorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file != nil {
+		r.w.Flush()
+	}
+}
+
+func (r *ReplayRecorder) RecordJoin(tick int64, playerID string, bot bool, team int) {
+	if r == nil {
+		return
+	}
+	r.write(replayEntry{Type: entryJoin, Tick: tick, PlayerID: playerID, Bot: bot, Team: team})
+}
+
+func (r *ReplayRecorder) RecordLeave(tick int64, playerID string) {
+	if r == nil {
+		return
+	}
+	r.write(replayEntry{Type: entryLeave, Tick: tick, PlayerID: playerID})
+}
+
+func (r *ReplayRecorder) RecordInput(tick int64, input PlayerInput) {
+	if r == nil {
+		return
+	}
+	r.write(replayEntry{Type: entryInput, Tick: tick, PlayerID: input.PlayerID, Input: &input})
+}
+
+// RecordTick writes a keyframe when one is due. Keyframes are also where the file gets flushed.
+func (r *ReplayRecorder) RecordTick(state GameState) {
+	if r == nil || r.keyframeEvery <= 0 || state.Tick%r.keyframeEvery != 0 {
+		return
+	}
+	r.write(replayEntry{Type: entryKeyframe, Tick: state.Tick, State: &state})
+	r.flush()
+}
+
+func (r *ReplayRecorder) Close(tick int64) {
+	if r == nil {
+		return
+	}
+	r.write(replayEntry{Type: entryEnd, Tick: tick})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	r.w.Flush()
+	r.file.Close()
+	r.file = nil
+}
+
+// ReplayPlayer re-broadcasts a recorded match into a hub room at a multiple of real time
+type ReplayPlayer struct {
+	Path   string
+	Speed  int // 1, 2 or 4
+	Hub    *Hub
+	Room   string
+	Tracks *tracks.Catalog // to follow the race between keyframes, optional
+}
+
+var ErrInvalidReplaySpeed = errors.New("replay speed must be 1, 2 or 4")
+
+func ValidReplaySpeed(speed int) bool {
+	return speed == 1 || speed == 2 || speed == 4
+}
+
+// Run plays the replay from start to end, or until ctx is cancelled
+func (p *ReplayPlayer) Run(ctx context.Context) error {
+	if !ValidReplaySpeed(p.Speed) {
+		return ErrInvalidReplaySpeed
+	}
+
+	file, err := os.Open(p.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+
+	var header replayEntry
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if header.Type != entryHeader || header.State == nil {
+		return fmt.Errorf("replay %s has no header", p.Path)
+	}
+	state := *header.State
+
+	// play back at the rate the match was recorded at
+	tickInterval := DefaultTickInterval
+	if header.TickIntervalMs > 0 {
+		tickInterval = time.Duration(header.TickIntervalMs) * time.Millisecond
+	}
+	ticker := time.NewTicker(tickInterval / time.Duration(p.Speed))
+	defer ticker.Stop()
+
+	// collisions, laps and positions are worked out by the server, not recorded, so
+	// follow the race the way the game did
+	var rc *race
+	var track *tracks.Track
+	if p.Tracks != nil && state.Track != "" {
+		if t, ok := p.Tracks.Get(state.Track); ok {
+			track = t
+			rc = newRace(track, state.Laps, state.Tick)
+		}
+	}
+	col := newCollider(track)
+
+	var next *replayEntry
+	for {
+		state.Tick++
+
+		// Apply this tick's entries in the order they were recorded, up to its
+		// keyframe when it has one. A keyframe has been stepped already.
+		keyframe := false
+		for !keyframe {
+			if next == nil {
+				var entry replayEntry
+				if err := dec.Decode(&entry); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				next = &entry
+			}
+			if next.Tick > state.Tick {
+				break
+			}
+			if next.Type == entryEnd {
+				// the game stopped after broadcasting the tick it was stamped with
+				if next.Tick < state.Tick {
+					return nil
+				}
+				break
+			}
+			keyframe = next.Type == entryKeyframe && next.Tick == state.Tick
+			applyReplayEntry(&state, *next, rc)
+			next = nil
+		}
+		switch {
+		case keyframe && rc != nil:
+			rc.follow(&state)
+		case !keyframe:
+			col.step(&state)
+			if rc != nil {
+				rc.step(&state, tickInterval)
+			}
+		}
+
+		stateBytes, _ := json.Marshal(state)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		p.Hub.broadcast <- Message{MatchID: p.Room, Payload: stateBytes}
+	}
+}
+
+func applyReplayEntry(state *GameState, entry replayEntry, rc *race) {
+	switch entry.Type {
+	case entryJoin:
+		car := newCarState()
+		car.Bot = entry.Bot
+		car.Team = entry.Team
+		if rc != nil {
+			rc.place(car, len(state.Players))
+		}
+		state.Players[entry.PlayerID] = car
+	case entryLeave:
+		delete(state.Players, entry.PlayerID)
+	case entryInput:
+		if entry.Input != nil {
+			applyInput(state, *entry.Input)
+		}
+	case entryKeyframe:
+		// resync to the authoritative snapshot
+		if entry.State != nil {
+			*state = *entry.State
+		}
+	}
+}
+
+// ServeReplayWs streams a recorded match to a single spectator in a room of its own,
+// so every viewer can watch at their own speed.
+func ServeReplayWs(hub *Hub, catalog *tracks.Catalog, w http.ResponseWriter, r *http.Request, replay models.Replay, speed int) {
+	if !ValidReplaySpeed(speed) {
+		http.Error(w, ErrInvalidReplaySpeed.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log := logger.FromContext(r.Context()).With(slog.String("match_id", replay.MatchID), slog.Int("speed", speed))
+
+	conn, err := Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error("Websocket upgrade failed", slog.String("error", err.Error()))
+		return
+	}
+
+	room := fmt.Sprintf("replay:%s:%d", replay.MatchID, time.Now().UnixNano())
+	client := &Client{
+		Hub:       hub,
+		MatchID:   room,
+		Spectator: true,
+		Conn:      conn,
+		Send:      make(chan []byte, 256),
+		closed:    make(chan struct{}),
+		log:       log,
+	}
+	client.Hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-client.closed
+		cancel()
+	}()
+
+	go func() {
+		defer cancel()
+		player := &ReplayPlayer{Path: replay.Path, Speed: speed, Hub: hub, Room: room, Tracks: catalog}
+		if err := player.Run(ctx); err != nil && err != context.Canceled {
+			log.Error("Error playing replay", slog.String("error", err.Error()))
+		}
+		// tell the viewer the replay is over
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished"),
+			time.Now().Add(time.Second))
+	}()
+}
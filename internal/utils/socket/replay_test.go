@@ -0,0 +1,95 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRecorder records into a file in a temp dir, the way ReplayStore.Open would
+func testRecorder(t *testing.T, initial GameState, keyframeEvery int64) (*ReplayRecorder, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), replayFileName("m"))
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &ReplayRecorder{file: file, w: bufio.NewWriter(file), keyframeEvery: keyframeEvery}
+	rec.write(replayEntry{Type: entryHeader, Tick: initial.Tick, State: &initial, TickIntervalMs: 1})
+	return rec, path
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	g := &Game{
+		MatchID:      "m",
+		State:        GameState{MatchID: "m", Players: make(map[string]*CarState)},
+		TickInterval: testTickInterval,
+		Bots:         make(map[string]*Bot),
+		teams:        make(map[string]int),
+		kicked:       make(map[string]bool),
+		collider:     newCollider(nil),
+		log:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	rec, path := testRecorder(t, g.State, 5)
+	g.Replay = rec
+
+	// a bot drives inside every tick, a player sends inputs between ticks
+	g.addBot("bot", BotHard)
+	g.AddPlayer("p")
+	const ticks = 23
+	live := make([][]byte, 0, ticks)
+	for i := 0; i < ticks; i++ {
+		g.step()
+		b, _ := json.Marshal(g.State)
+		live = append(live, b)
+		car := g.State.Players["p"]
+		g.handleInput(testInput(car.X+3, car.Y+1, car.Angle+0.1, 3))
+	}
+	g.Replay.Close(g.State.Tick)
+
+	hub := &Hub{broadcast: make(chan Message)}
+	player := &ReplayPlayer{Path: path, Speed: 4, Hub: hub, Room: "replay"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- player.Run(ctx) }()
+
+	var played []GameState
+	var last int64
+	for playing := true; playing; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			playing = false
+		case msg := <-hub.broadcast:
+			var state GameState
+			if err := json.Unmarshal(msg.Payload, &state); err != nil {
+				t.Fatal(err)
+			}
+			if state.Tick <= last {
+				t.Fatalf("tick %d played after tick %d", state.Tick, last)
+			}
+			last = state.Tick
+			played = append(played, state)
+		}
+	}
+	if len(played) != ticks {
+		t.Fatalf("played %d ticks, want %d", len(played), ticks)
+	}
+
+	// without a track playback follows the game exactly, keyframes or not
+	for i, state := range played {
+		b, _ := json.Marshal(state)
+		if string(b) != string(live[i]) {
+			t.Errorf("tick %d played as\n%s\nwant\n%s", state.Tick, b, live[i])
+		}
+	}
+}
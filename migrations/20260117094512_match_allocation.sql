@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE matches ADD COLUMN region TEXT;
+ALTER TABLE matches ADD COLUMN node_id TEXT;
+ALTER TABLE matches ADD COLUMN endpoint TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE matches DROP COLUMN endpoint;
+ALTER TABLE matches DROP COLUMN node_id;
+ALTER TABLE matches DROP COLUMN region;
+-- +goose StatementEnd
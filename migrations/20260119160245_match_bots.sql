@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE matches_players ADD COLUMN is_bot INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE matches ADD COLUMN bot_difficulty TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE matches DROP COLUMN bot_difficulty;
+ALTER TABLE matches_players DROP COLUMN is_bot;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE matches ADD COLUMN status TEXT NOT NULL DEFAULT 'live';
+ALTER TABLE matches ADD COLUMN ended_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS match_results (
+    match_id TEXT NOT NULL,
+    player_id TEXT NOT NULL,
+    is_bot INTEGER NOT NULL DEFAULT 0,
+    tick INTEGER NOT NULL,
+    x REAL NOT NULL,
+    y REAL NOT NULL,
+    damaged INTEGER NOT NULL DEFAULT 0,
+    partial INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (match_id, player_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS match_results;
+ALTER TABLE matches DROP COLUMN ended_at;
+ALTER TABLE matches DROP COLUMN status;
+-- +goose StatementEnd
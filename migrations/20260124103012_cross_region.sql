@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE players ADD COLUMN latency TEXT;
+ALTER TABLE matches ADD COLUMN cross_region INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE matches_players ADD COLUMN home_region TEXT;
+ALTER TABLE matches_players ADD COLUMN ping INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE matches_players DROP COLUMN ping;
+ALTER TABLE matches_players DROP COLUMN home_region;
+ALTER TABLE matches DROP COLUMN cross_region;
+ALTER TABLE players DROP COLUMN latency;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE players ADD COLUMN mode TEXT;
+ALTER TABLE matches ADD COLUMN mode TEXT;
+ALTER TABLE matches_players ADD COLUMN team INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE matches_players DROP COLUMN team;
+ALTER TABLE matches DROP COLUMN mode;
+ALTER TABLE players DROP COLUMN mode;
+-- +goose StatementEnd
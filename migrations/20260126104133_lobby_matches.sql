@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE matches ADD COLUMN lobby_code TEXT;
+ALTER TABLE matches ADD COLUMN track TEXT;
+ALTER TABLE matches ADD COLUMN laps INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE matches DROP COLUMN laps;
+ALTER TABLE matches DROP COLUMN track;
+ALTER TABLE matches DROP COLUMN lobby_code;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS cheat_incidents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    match_id TEXT NOT NULL,
+    player_id TEXT NOT NULL,
+    tick INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    score REAL NOT NULL,
+    violations TEXT NOT NULL,
+    detail TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_cheat_incidents_player ON cheat_incidents (player_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_cheat_incidents_player;
+DROP TABLE IF EXISTS cheat_incidents;
+-- +goose StatementEnd
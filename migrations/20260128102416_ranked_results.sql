@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE match_results ADD COLUMN ranked INTEGER NOT NULL DEFAULT 1;
+UPDATE match_results SET ranked = 0
+    WHERE match_id IN (SELECT id FROM matches WHERE lobby_code IS NOT NULL);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE match_results DROP COLUMN ranked;
+-- +goose StatementEnd
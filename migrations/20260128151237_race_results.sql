@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE match_results ADD COLUMN position INTEGER;
+ALTER TABLE match_results ADD COLUMN lap_times TEXT;
+ALTER TABLE match_results ADD COLUMN best_lap INTEGER;
+ALTER TABLE match_results ADD COLUMN finished INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE match_results ADD COLUMN race_time INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE match_results DROP COLUMN race_time;
+ALTER TABLE match_results DROP COLUMN finished;
+ALTER TABLE match_results DROP COLUMN best_lap;
+ALTER TABLE match_results DROP COLUMN lap_times;
+ALTER TABLE match_results DROP COLUMN position;
+-- +goose StatementEnd